@@ -0,0 +1,175 @@
+package config
+
+import (
+	"crypto/sha256"
+	"os"
+	"sync"
+	"time"
+)
+
+// configCache keeps parsed configs and the config list in memory so
+// repeated polling doesn't re-read and re-parse every file. Entries are
+// validated against the file's modification time and size on each use,
+// which costs a stat instead of a read, and picks up files edited outside
+// the app. Files modified too recently for their timestamp to be trusted
+// are also compared by content, see fileStamp. Writes through the Manager
+// drop the entry explicitly.
+type configCache struct {
+	mu       sync.Mutex
+	names    []string
+	dirStamp fileStamp               // Of the configs directory when names was read
+	configs  map[string]cachedConfig // Config file path -> parsed config
+}
+
+// stampGranularity is the coarsest modification time resolution expected
+// of a filesystem; FAT records 2 seconds
+const stampGranularity = 2 * time.Second
+
+// fileStamp identifies a version of a file or directory. A write within
+// the same timestamp tick that keeps the size leaves modification time and
+// size alone, so a stamp taken that soon after the last write is racy and
+// also carries the sum of the contents. Once a file is stamped later than
+// that, any further write moves its modification time.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	racy    bool              // Taken within stampGranularity of modTime
+	sum     [sha256.Size]byte // Of the contents, set by withContents
+}
+
+type cachedConfig struct {
+	stamp fileStamp
+	cfg   *Config
+}
+
+// stampOf stamps a file or directory by its info. A racy stamp of a file
+// still needs withContents.
+func stampOf(info os.FileInfo) fileStamp {
+	return fileStamp{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		racy:    time.Since(info.ModTime()) < stampGranularity,
+	}
+}
+
+// withContents returns s with the sum of data, the contents it stamps
+func (s fileStamp) withContents(data []byte) fileStamp {
+	s.sum = sha256.Sum256(data)
+	return s
+}
+
+// matches reports whether newer, taken after s, stamps the same version.
+// When s is racy, newer must have its contents' sum.
+func (s fileStamp) matches(newer fileStamp) bool {
+	if !s.modTime.Equal(newer.modTime) || s.size != newer.size {
+		return false
+	}
+	return !s.racy || s.sum == newer.sum
+}
+
+func newConfigCache() *configCache {
+	return &configCache{configs: make(map[string]cachedConfig)}
+}
+
+// list returns the cached config names if the directory hasn't changed
+func (c *configCache) list(stamp fileStamp) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.names == nil || !c.dirStamp.matches(stamp) {
+		return nil, false
+	}
+	return append([]string(nil), c.names...), true
+}
+
+// storeList caches the config names. A racy directory stamp can't be
+// checked by content, so those lists aren't kept.
+func (c *configCache) storeList(stamp fileStamp, names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stamp.racy {
+		c.names = nil
+		return
+	}
+	c.names = append(make([]string, 0, len(names)), names...)
+	c.dirStamp = stamp
+}
+
+// get returns a copy of the cached config at path if the file hasn't
+// changed. A racy entry is checked against the file's contents, and
+// replaced by stamp once stamp no longer is racy.
+func (c *configCache) get(path string, stamp fileStamp) (*Config, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.configs[path]
+	if !ok {
+		return nil, false
+	}
+	if entry.stamp.racy {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false
+		}
+		stamp = stamp.withContents(data)
+	}
+	if !entry.stamp.matches(stamp) {
+		return nil, false
+	}
+	if entry.stamp.racy && !stamp.racy {
+		entry.stamp = stamp
+		c.configs[path] = entry
+	}
+	return entry.cfg.clone(), true
+}
+
+// store caches cfg, parsed from the file at path. A racy stamp must have
+// the sum of the contents cfg was parsed from.
+func (c *configCache) store(path string, stamp fileStamp, cfg *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configs[path] = cachedConfig{stamp: stamp, cfg: cfg.clone()}
+}
+
+// forget drops the config at path and the config list
+func (c *configCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.configs, path)
+	c.names = nil
+}
+
+// clone returns a deep copy of the config, so callers can modify what
+// LoadConfig returns without touching the cache
+func (c *Config) clone() *Config {
+	cp := *c
+	if c.Files != nil {
+		cp.Files = make([]FileEntry, len(c.Files))
+		for i, f := range c.Files {
+			cp.Files[i] = f.clone()
+		}
+	}
+	return &cp
+}
+
+func (e FileEntry) clone() FileEntry {
+	if e.UseToken != nil {
+		useToken := *e.UseToken
+		e.UseToken = &useToken
+	}
+	if e.ExtractedFiles != nil {
+		e.ExtractedFiles = append([]ExtractedFile(nil), e.ExtractedFiles...)
+	}
+	e.Headers = cloneMap(e.Headers)
+	e.Cookies = cloneMap(e.Cookies)
+	return e
+}
+
+func cloneMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rewriteKeepingStamp replaces the contents of path with data of the same
+// size and restores its modification time, like a second write within one
+// tick of a coarse filesystem clock
+func rewriteKeepingStamp(t *testing.T, path string, data []byte) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != info.Size() {
+		t.Fatalf("rewrite changes the size from %d to %d", info.Size(), len(data))
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigSeesSameStampEdit(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "models.json")
+	if err := os.WriteFile(path, []byte(`{"name": "aaaaaa"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := m.LoadConfig("models"); err != nil || cfg.Name != "aaaaaa" {
+		t.Fatalf("LoadConfig = %+v, %v", cfg, err)
+	}
+
+	rewriteKeepingStamp(t, path, []byte(`{"name": "bbbbbb"}`))
+	if cfg, err := m.LoadConfig("models"); err != nil || cfg.Name != "bbbbbb" {
+		t.Fatalf("LoadConfig after an edit with the same stamp = %+v, %v, want the new name", cfg, err)
+	}
+}
+
+func TestCachedConfigSettles(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "models.json")
+	if err := os.WriteFile(path, []byte(`{"name": "models"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoadConfig("models"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.cache.configs[path].stamp.racy {
+		t.Fatal("fresh file wasn't stamped racy")
+	}
+
+	// Once the file is older than the timestamp granularity, its stamp
+	// alone identifies it
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoadConfig("models"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoadConfig("models"); err != nil {
+		t.Fatal(err)
+	}
+	if m.cache.configs[path].stamp.racy {
+		t.Error("cached stamp is still racy")
+	}
+}
+
+func TestFileStampMatches(t *testing.T) {
+	now := time.Now()
+	clean := fileStamp{modTime: now, size: 10}
+	racy := fileStamp{modTime: now, size: 10, racy: true}.withContents([]byte("0123456789"))
+
+	tests := []struct {
+		name       string
+		old, newer fileStamp
+		want       bool
+	}{
+		{"same", clean, clean, true},
+		{"other time", clean, fileStamp{modTime: now.Add(time.Second), size: 10}, false},
+		{"other size", clean, fileStamp{modTime: now, size: 11}, false},
+		{"racy, same contents", racy, fileStamp{modTime: now, size: 10}.withContents([]byte("0123456789")), true},
+		{"racy, other contents", racy, fileStamp{modTime: now, size: 10}.withContents([]byte("9876543210")), false},
+		{"racy, contents unread", racy, fileStamp{modTime: now, size: 10}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.old.matches(tt.newer); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,12 +1,31 @@
 package config
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxBackups is how many previous versions are kept per config
+	maxBackups = 5
+
+	// backupTimeFormat names backups so they sort chronologically
+	backupTimeFormat = "20060102-150405.000"
+
+	// maxFileNameLength caps sanitized names in bytes, leaving room under
+	// the usual 255 byte limit for suffixes like .tmp or .json.<id>.bak
+	maxFileNameLength = 200
 )
 
 // ExtractedFile represents a file extracted from an archive
@@ -17,29 +36,89 @@ type ExtractedFile struct {
 
 // FileEntry represents a single file in the config
 type FileEntry struct {
-	ID             string          `json:"id"`
-	URL            string          `json:"url"`
-	FileName       string          `json:"fileName"`
-	Folder         string          `json:"folder"`         // Relative to root directory
-	Title          string          `json:"title"`          // Human-readable title
-	Description    string          `json:"description"`    // Description with clickable links
-	SourceURL      string          `json:"sourceUrl"`      // Link to source page (e.g. model page)
-	UseToken       bool            `json:"useToken"`       // Whether to append auth token to URL
-	ExtractedFiles []ExtractedFile `json:"extractedFiles"` // List of files extracted from archive
+	ID             string            `json:"id"`
+	URL            string            `json:"url"`
+	FileName       string            `json:"fileName"`
+	Folder         string            `json:"folder"`             // Relative to root directory, empty inherits DefaultFolder
+	Title          string            `json:"title"`              // Human-readable title
+	Description    string            `json:"description"`        // Description with clickable links
+	SourceURL      string            `json:"sourceUrl"`          // Link to source page (e.g. model page)
+	UseToken       *bool             `json:"useToken,omitempty"` // Whether to append auth token to URL, unset inherits DefaultUseToken
+	ExtractedFiles []ExtractedFile   `json:"extractedFiles"`     // List of files extracted from archive
+	SHA256         string            `json:"sha256,omitempty"`   // Expected SHA256 checksum (hex), verified after download
+	Headers        map[string]string `json:"headers,omitempty"`  // Extra HTTP headers sent with requests for this file
+	Cookies        map[string]string `json:"cookies,omitempty"`  // Cookies sent with requests for this file, e.g. a login session
+	Username       string            `json:"username,omitempty"` // HTTP Basic Auth user, replaces the HuggingFace bearer token
+	Password       string            `json:"password,omitempty"` // HTTP Basic Auth password
+	Priority       int               `json:"priority,omitempty"` // Higher priority entries are started first in a batch
+
+	// Token replaces the config's token for this entry, e.g. for a host
+	// other than Civitai. It is sent even without UseToken.
+	Token string `json:"token,omitempty"`
+
+	// AllowEmpty accepts a download below the minimum size, for files that
+	// are legitimately empty or tiny
+	AllowEmpty bool `json:"allowEmpty,omitempty"`
+
+	// Size is the expected file size in bytes, checked after download for
+	// hosts without checksums. Zero means unknown.
+	Size int64 `json:"size,omitempty"`
 }
 
 // Config represents a download configuration
 type Config struct {
-	Name          string      `json:"name"`
-	RootDirectory string      `json:"rootDirectory"`
-	CivitaiToken  string      `json:"civitaiToken"` // API token for civitai.com
-	Files         []FileEntry `json:"files"`
+	Name             string      `json:"name"`
+	RootDirectory    string      `json:"rootDirectory"`
+	CivitaiToken     string      `json:"civitaiToken,omitempty"`     // API token for civitai.com
+	HuggingFaceToken string      `json:"huggingFaceToken,omitempty"` // Access token for huggingface.co, sent as a bearer header
+	ProxyURL         string      `json:"proxyUrl,omitempty"`         // HTTP(S) proxy for downloads, overrides HTTP_PROXY/HTTPS_PROXY
+	WebhookURL       string      `json:"webhookUrl,omitempty"`       // Receives a JSON POST when a download completes or fails
+	Files            []FileEntry `json:"files"`
+
+	EntryDefaults
+}
+
+// EntryDefaults are values file entries inherit when they leave the field
+// unset. Entries are resolved against them right before they are used, so
+// the saved entries keep their own (possibly empty) values.
+type EntryDefaults struct {
+	DefaultFolder   string `json:"defaultFolder,omitempty"`   // Folder of entries without one, "." keeps an entry in the root
+	DefaultUseToken bool   `json:"defaultUseToken,omitempty"` // UseToken of entries that don't set it
+}
+
+// Resolve returns entry with the defaults filled in
+func (d EntryDefaults) Resolve(entry FileEntry) FileEntry {
+	if entry.Folder == "" {
+		entry.Folder = d.DefaultFolder
+	}
+	if entry.UseToken == nil {
+		useToken := d.DefaultUseToken
+		entry.UseToken = &useToken
+	}
+	return entry
+}
+
+// ResolveAll returns a copy of entries with the defaults filled in
+func (d EntryDefaults) ResolveAll(entries []FileEntry) []FileEntry {
+	resolved := make([]FileEntry, len(entries))
+	for i, entry := range entries {
+		resolved[i] = d.Resolve(entry)
+	}
+	return resolved
+}
+
+// TokenEnabled reports whether the auth token is sent for the entry. Call
+// it on resolved entries so DefaultUseToken applies.
+func (e FileEntry) TokenEnabled() bool {
+	return e.UseToken != nil && *e.UseToken
 }
 
 // Manager handles config operations
 type Manager struct {
 	configsDir string
 	mu         sync.RWMutex
+	cache      *configCache
+	watchers   configWatchers
 }
 
 // NewManager creates a new config manager
@@ -47,7 +126,7 @@ func NewManager(configsDir string) (*Manager, error) {
 	if err := os.MkdirAll(configsDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create configs directory: %w", err)
 	}
-	return &Manager{configsDir: configsDir}, nil
+	return &Manager{configsDir: configsDir, cache: newConfigCache()}, nil
 }
 
 // ListConfigs returns all available config names
@@ -55,6 +134,14 @@ func (m *Manager) ListConfigs() ([]string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	// Adding, removing or renaming a file updates the directory's mtime
+	info, statErr := os.Stat(m.configsDir)
+	if statErr == nil {
+		if names, ok := m.cache.list(stampOf(info)); ok {
+			return names, nil
+		}
+	}
+
 	entries, err := os.ReadDir(m.configsDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read configs directory: %w", err)
@@ -67,6 +154,9 @@ func (m *Manager) ListConfigs() ([]string, error) {
 			configs = append(configs, name)
 		}
 	}
+	if statErr == nil {
+		m.cache.storeList(stampOf(info), configs)
+	}
 	return configs, nil
 }
 
@@ -74,8 +164,19 @@ func (m *Manager) ListConfigs() ([]string, error) {
 func (m *Manager) LoadConfig(name string) (*Config, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return m.loadConfig(name)
+}
 
+// loadConfig is LoadConfig for callers holding m.mu
+func (m *Manager) loadConfig(name string) (*Config, error) {
 	path := filepath.Join(m.configsDir, name+".json")
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		if cfg, ok := m.cache.get(path, stampOf(info)); ok {
+			return cfg, nil
+		}
+	}
+
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -88,6 +189,9 @@ func (m *Manager) LoadConfig(name string) (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if statErr == nil {
+		m.cache.store(path, stampOf(info).withContents(data), &cfg)
+	}
 	return &cfg, nil
 }
 
@@ -95,10 +199,17 @@ func (m *Manager) LoadConfig(name string) (*Config, error) {
 func (m *Manager) SaveConfig(cfg *Config) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.saveConfig(cfg)
+}
 
+// saveConfig is SaveConfig for callers holding m.mu
+func (m *Manager) saveConfig(cfg *Config) error {
 	if cfg.Name == "" {
 		return fmt.Errorf("config name cannot be empty")
 	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
 
 	// Sanitize name for filename
 	safeName := sanitizeFileName(cfg.Name)
@@ -108,19 +219,433 @@ func (m *Manager) SaveConfig(cfg *Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
+	return m.writeConfigFile(safeName, path, data)
+}
+
+// ErrConfigExists is returned when a copy or rename would overwrite another config
+var ErrConfigExists = errors.New("config already exists")
+
+// CopyConfig saves a duplicate of config src named dst and returns the
+// name it is stored under. Entries get new IDs, so downloads of the two
+// configs aren't mixed up in progress tracking. An existing config named
+// dst is only replaced when force is set.
+func (m *Manager) CopyConfig(src, dst string, force bool) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if dst == "" {
+		return "", fmt.Errorf("config name cannot be empty")
+	}
+	cfg, err := m.loadConfig(src)
+	if err != nil {
+		return "", err
+	}
+	safeName := sanitizeFileName(dst)
+	if safeName == sanitizeFileName(src) {
+		return "", fmt.Errorf("%w: can't copy '%s' onto itself", ErrConfigExists, safeName)
+	}
+	if _, err := os.Stat(filepath.Join(m.configsDir, safeName+".json")); err == nil && !force {
+		return "", fmt.Errorf("%w: '%s'", ErrConfigExists, safeName)
+	}
+
+	cfg.Name = dst
+	for i := range cfg.Files {
+		cfg.Files[i].ID = ""
+	}
+	cfg.AssignIDs(false)
+	if err := m.saveConfig(cfg); err != nil {
+		return "", err
+	}
+	return safeName, nil
+}
+
+// RenameConfig renames config oldName to newName and returns the name it
+// is now stored under. The new file is written before the old one is
+// removed, so a failed rename leaves the config where it was. Backups move
+// along with it. Renaming onto another existing config fails with
+// ErrConfigExists.
+func (m *Manager) RenameConfig(oldName, newName string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if newName == "" {
+		return "", fmt.Errorf("config name cannot be empty")
+	}
+	cfg, err := m.loadConfig(oldName)
+	if err != nil {
+		return "", err
+	}
+	oldPath := filepath.Join(m.configsDir, oldName+".json")
+	safeName := sanitizeFileName(newName)
+	newPath := filepath.Join(m.configsDir, safeName+".json")
+	if safeName == oldName {
+		// Only the display name changes, the file stays
+		cfg.Name = newName
+		return safeName, m.saveConfig(cfg)
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return "", fmt.Errorf("%w: '%s'", ErrConfigExists, safeName)
+	}
+
+	cfg.Name = newName
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := WriteFileAtomic(newPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write config: %w", err)
+	}
+	m.cache.forget(newPath)
+
+	if backups, err := m.listBackups(oldName); err == nil {
+		for _, b := range backups {
+			os.Rename(m.backupPath(oldName, b.ID), m.backupPath(safeName, b.ID))
+		}
+	}
+	defer m.cache.forget(oldPath)
+	if err := os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+		return safeName, fmt.Errorf("renamed, but failed to remove the old config: %w", err)
+	}
+	return safeName, nil
+}
+
+// writeConfigFile backs up the current config file, if any, then overwrites it with data
+func (m *Manager) writeConfigFile(safeName, path string, data []byte) error {
+	defer m.cache.forget(path)
+	if err := m.backupConfig(safeName, path); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 	return nil
 }
 
+// Backup describes a saved previous version of a config
+type Backup struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
+
+// backupPath returns the file holding backup id of a config
+func (m *Manager) backupPath(safeName, id string) string {
+	return filepath.Join(m.configsDir, safeName+".json."+id+".bak")
+}
+
+// backupConfig copies the config file at path to a timestamped backup and
+// deletes the oldest backups beyond maxBackups
+func (m *Manager) backupConfig(safeName, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Nothing to back up yet
+		}
+		return fmt.Errorf("failed to read config for backup: %w", err)
+	}
+
+	id := time.Now().Format(backupTimeFormat)
+	if err := WriteFileAtomic(m.backupPath(safeName, id), data, 0644); err != nil {
+		return fmt.Errorf("failed to write config backup: %w", err)
+	}
+
+	backups, err := m.listBackups(safeName)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(len(backups), maxBackups):] {
+		os.Remove(m.backupPath(safeName, b.ID))
+	}
+	return nil
+}
+
+// ListBackups returns the backups of a config, newest first
+func (m *Manager) ListBackups(name string) ([]Backup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.listBackups(sanitizeFileName(name))
+}
+
+func (m *Manager) listBackups(safeName string) ([]Backup, error) {
+	entries, err := os.ReadDir(m.configsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configs directory: %w", err)
+	}
+
+	prefix := safeName + ".json."
+	backups := []Backup{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(fileName, prefix) || !strings.HasSuffix(fileName, ".bak") {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(fileName, prefix), ".bak")
+		created, err := time.ParseInLocation(backupTimeFormat, id, time.Local)
+		if err != nil {
+			continue // Not one of ours
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{ID: id, Created: created, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID > backups[j].ID
+	})
+	return backups, nil
+}
+
+// RestoreBackup replaces a config with one of its backups. The current
+// version is backed up first so the restore can itself be undone.
+func (m *Manager) RestoreBackup(name, backupID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Only accept IDs we generate, they double as file names
+	if _, err := time.Parse(backupTimeFormat, backupID); err != nil {
+		return fmt.Errorf("invalid backup id '%s'", backupID)
+	}
+
+	safeName := sanitizeFileName(name)
+	data, err := os.ReadFile(m.backupPath(safeName, backupID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("backup '%s' of config '%s' not found", backupID, name)
+		}
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse backup: %w", err)
+	}
+
+	return m.writeConfigFile(safeName, filepath.Join(m.configsDir, safeName+".json"), data)
+}
+
+// Warnings returns the warning-level issues of Validate
+func (c *Config) Warnings() []ValidationIssue {
+	var warnings []ValidationIssue
+	for _, issue := range Validate(c) {
+		if issue.Severity == SeverityWarning {
+			warnings = append(warnings, issue)
+		}
+	}
+	return warnings
+}
+
+// ErrInvalidConfig is returned when a config fails validation
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validate runs the checks of Validate and returns an error listing the
+// problems that keep the config from being saved
+func (c *Config) Validate() error {
+	var problems []string
+	for _, issue := range Validate(c) {
+		if issue.Severity == SeverityError {
+			problems = append(problems, issue.String())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// secretHeaders are per-file headers that carry credentials
+var secretHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// secretHeaderWords mark other headers as credentials when their name
+// contains one, e.g. X-Api-Key, X-Auth-Token or Private-Token. Lowercase.
+var secretHeaderWords = []string{"token", "key", "secret", "auth"}
+
+// StripSecrets clears auth tokens, the webhook, proxy credentials and credential headers
+// so the config can be shared, and reports whether there were any. New
+// secret fields belong here too.
+func (c *Config) StripSecrets() bool {
+	stripped := c.CivitaiToken != "" || c.HuggingFaceToken != "" || c.WebhookURL != ""
+	c.CivitaiToken = ""
+	c.HuggingFaceToken = ""
+	c.WebhookURL = "" // Webhook URLs embed their own access token
+
+	if u, err := url.Parse(c.ProxyURL); err == nil && u.User != nil {
+		u.User = nil
+		c.ProxyURL = u.String()
+		stripped = true
+	}
+
+	for i := range c.Files {
+		if len(c.Files[i].Cookies) > 0 || c.Files[i].Password != "" || c.Files[i].Token != "" {
+			stripped = true
+		}
+		c.Files[i].Cookies = nil
+		c.Files[i].Password = ""
+		c.Files[i].Token = ""
+
+		headers := c.Files[i].Headers
+		if len(headers) == 0 {
+			continue
+		}
+		clean := make(map[string]string, len(headers))
+		for k, v := range headers {
+			if isSecretHeader(k) {
+				stripped = true
+			} else {
+				clean[k] = v
+			}
+		}
+		c.Files[i].Headers = clean
+	}
+	return stripped
+}
+
+// KeepSecrets fills secrets missing from c with the ones from prev, so that
+// importing a stripped export over an existing config doesn't wipe them.
+// Proxy credentials are kept while the proxy stays the same. Per-file
+// secrets are matched by entry ID, and only kept while the entry's URL
+// stays on the same scheme and host, so they never reach a new host.
+func (c *Config) KeepSecrets(prev *Config) {
+	if c.CivitaiToken == "" {
+		c.CivitaiToken = prev.CivitaiToken
+	}
+	if c.HuggingFaceToken == "" {
+		c.HuggingFaceToken = prev.HuggingFaceToken
+	}
+	if c.WebhookURL == "" {
+		c.WebhookURL = prev.WebhookURL
+	}
+	if u, err := url.Parse(c.ProxyURL); err == nil && u.User == nil && c.ProxyURL != "" {
+		if p, err := url.Parse(prev.ProxyURL); err == nil && p.User != nil && p.Scheme == u.Scheme && p.Host == u.Host {
+			u.User = p.User
+			c.ProxyURL = u.String()
+		}
+	}
+
+	prevFiles := make(map[string]*FileEntry, len(prev.Files))
+	for i := range prev.Files {
+		prevFiles[prev.Files[i].ID] = &prev.Files[i]
+	}
+	for i := range c.Files {
+		p, ok := prevFiles[c.Files[i].ID]
+		if !ok || !sameOrigin(c.Files[i].URL, p.URL) {
+			continue
+		}
+		if len(c.Files[i].Cookies) == 0 {
+			c.Files[i].Cookies = p.Cookies
+		}
+		if c.Files[i].Password == "" && c.Files[i].Username == p.Username {
+			c.Files[i].Password = p.Password
+		}
+		if c.Files[i].Token == "" {
+			c.Files[i].Token = p.Token
+		}
+		for k, v := range p.Headers {
+			if !isSecretHeader(k) || hasHeader(c.Files[i].Headers, k) {
+				continue
+			}
+			if c.Files[i].Headers == nil {
+				c.Files[i].Headers = make(map[string]string)
+			}
+			c.Files[i].Headers[k] = v
+		}
+	}
+}
+
+// sameOrigin reports whether URLs a and b share scheme and host
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// hasHeader reports whether headers sets name, ignoring case
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSecretHeader(name string) bool {
+	for _, h := range secretHeaders {
+		if strings.EqualFold(name, h) {
+			return true
+		}
+	}
+	lower := strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// IDChange records a file entry whose ID was assigned by AssignIDs
+type IDChange struct {
+	Index int    `json:"index"` // Position of the entry in Files
+	OldID string `json:"oldId"`
+	NewID string `json:"newId"`
+}
+
+// AssignIDs gives entries with an empty ID a freshly generated one. When
+// fixDuplicates is set, entries reusing an earlier entry's ID get a new ID
+// too; otherwise duplicates are left for Validate to reject.
+func (c *Config) AssignIDs(fixDuplicates bool) []IDChange {
+	var changes []IDChange
+	seen := make(map[string]bool)
+	for i := range c.Files {
+		id := c.Files[i].ID
+		if id == "" || (fixDuplicates && seen[id]) {
+			newID := NewID()
+			changes = append(changes, IDChange{Index: i, OldID: id, NewID: newID})
+			c.Files[i].ID = newID
+		}
+		seen[c.Files[i].ID] = true
+	}
+	return changes
+}
+
+// NewID returns a random version 4 UUID
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// isDownloadURL reports whether rawURL is an absolute http or https URL
+func isDownloadURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // DeleteConfig deletes a config by name
 func (m *Manager) DeleteConfig(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	path := filepath.Join(m.configsDir, name+".json")
+	defer m.cache.forget(path)
 	if err := os.Remove(path); err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("config '%s' not found", name)
@@ -130,53 +655,361 @@ func (m *Manager) DeleteConfig(name string) error {
 	return nil
 }
 
-// GetFoldersInRoot returns all folders within the root directory
-func GetFoldersInRoot(rootDir string) ([]string, error) {
-	if rootDir == "" {
-		return nil, fmt.Errorf("root directory not specified")
+// DefaultFolderExcludes are the folder name patterns skipped by folder
+// scans that don't set their own: hidden folders and caches
+var DefaultFolderExcludes = []string{".*", "__pycache__", "node_modules"}
+
+// FolderScanOptions limits what GetFoldersInRoot and GetFolderTree list
+type FolderScanOptions struct {
+	MaxDepth int      // Levels below the start folder to list, <= 0 is unlimited
+	Exclude  []string // Folder name patterns (filepath.Match) skipped with their contents, nil uses DefaultFolderExcludes
+	Folder   string   // List below this folder instead of the root, e.g. to lazy-load a tree
+
+	// FollowSymlinks lists symlinked folders and their contents. A link
+	// back to a folder being scanned is listed but not descended into, and
+	// broken links are skipped.
+	FollowSymlinks bool
+}
+
+// FolderNode is a folder in the tree returned by GetFolderTree
+type FolderNode struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"` // Relative to the root directory
+	Children []FolderNode `json:"children,omitempty"`
+	HasMore  bool         `json:"hasMore,omitempty"` // Has subfolders below MaxDepth that weren't listed
+}
+
+// GetFoldersInRoot returns the folders within the root directory as a flat
+// list of relative paths, parents before their children
+func GetFoldersInRoot(rootDir string, opts FolderScanOptions) ([]string, error) {
+	nodes, err := GetFolderTree(rootDir, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	// Expand ~ to home directory
-	if strings.HasPrefix(rootDir, "~") {
-		home, err := os.UserHomeDir()
-		if err != nil {
+	var folders []string
+	var flatten func(nodes []FolderNode)
+	flatten = func(nodes []FolderNode) {
+		for _, n := range nodes {
+			folders = append(folders, n.Path)
+			flatten(n.Children)
+		}
+	}
+	flatten(nodes)
+	return folders, nil
+}
+
+// GetFolderTree returns the folders within the root directory as a tree
+func GetFolderTree(rootDir string, opts FolderScanOptions) ([]FolderNode, error) {
+	root, err := ResolveRoot(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	start := root
+	if opts.Folder != "" {
+		if start, err = SafeJoin(root, opts.Folder); err != nil {
 			return nil, err
 		}
-		rootDir = filepath.Join(home, rootDir[1:])
+		if info, err := os.Stat(start); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("folder '%s' not found", opts.Folder)
+		}
 	}
 
-	var folders []string
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+	s := &folderScanner{
+		root:     root,
+		maxDepth: opts.MaxDepth,
+		exclude:  opts.Exclude,
+		follow:   opts.FollowSymlinks,
+		scanning: make(map[string]bool),
+	}
+	if s.exclude == nil {
+		s.exclude = DefaultFolderExcludes
+	}
+	return s.scan(start, 1), nil
+}
+
+// folderScanner walks the folders below a root directory
+type folderScanner struct {
+	root     string
+	maxDepth int
+	exclude  []string
+	follow   bool
+	scanning map[string]bool // Resolved paths of the folders being scanned, to break symlink cycles
+}
+
+// scan lists the folders in dir, recursing until maxDepth
+func (s *folderScanner) scan(dir string, depth int) []FolderNode {
+	if s.follow {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil || s.scanning[real] {
+			return nil
+		}
+		s.scanning[real] = true
+		defer delete(s.scanning, real)
+	}
+
+	var nodes []FolderNode
+	for _, name := range s.subfolders(dir) {
+		path := filepath.Join(dir, name)
+		rel, err := filepath.Rel(s.root, path)
 		if err != nil {
-			return nil // Skip inaccessible paths
+			continue
 		}
-		if info.IsDir() && path != rootDir {
-			rel, err := filepath.Rel(rootDir, path)
-			if err == nil {
-				folders = append(folders, rel)
-			}
+		node := FolderNode{Name: name, Path: rel}
+		if s.maxDepth > 0 && depth >= s.maxDepth {
+			node.HasMore = len(s.subfolders(path)) > 0
+		} else {
+			node.Children = s.scan(path, depth+1)
 		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// subfolders returns the names of the folders in dir that aren't excluded.
+// Inaccessible folders and broken symlinks are skipped.
+func (s *folderScanner) subfolders(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
 		return nil
-	})
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() == TrashFolder || excludedFolder(entry.Name(), s.exclude) {
+			continue
+		}
+		isDir := entry.IsDir()
+		if !isDir && s.follow && entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(filepath.Join(dir, entry.Name()))
+			isDir = err == nil && info.IsDir()
+		}
+		if isDir {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// excludedFolder reports whether name matches one of the exclude patterns
+func excludedFolder(name string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateFolder creates rel and any missing parents inside the root directory
+func CreateFolder(root, rel string) error {
+	path, err := folderPath(root, rel)
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan directory: %w", err)
+		return err
 	}
-	return folders, nil
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+	return nil
 }
 
-// ExpandPath expands ~ and returns absolute path
-func ExpandPath(path string) string {
-	if strings.HasPrefix(path, "~") {
-		home, err := os.UserHomeDir()
-		if err == nil {
-			return filepath.Join(home, path[1:])
+// RemoveFolder deletes rel inside the root directory. Unless recursive is
+// set, only empty folders are removed.
+func RemoveFolder(root, rel string, recursive bool) error {
+	path, err := folderPath(root, rel)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("folder '%s' not found", rel)
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a folder", rel)
+	}
+
+	if recursive {
+		err = os.RemoveAll(path)
+	} else {
+		entries, readErr := os.ReadDir(path)
+		if readErr != nil {
+			return fmt.Errorf("failed to read folder: %w", readErr)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), MetaFileSuffix) {
+				return fmt.Errorf("folder '%s' is not empty", rel)
+			}
+		}
+		// Only leftover sidecars, which go with the folder
+		for _, entry := range entries {
+			os.Remove(filepath.Join(path, entry.Name()))
+		}
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove folder: %w", err)
+	}
+	return nil
+}
+
+// folderPath resolves a folder inside root, rejecting the root itself
+func folderPath(root, rel string) (string, error) {
+	base, err := ResolveRoot(root)
+	if err != nil {
+		return "", err
+	}
+	path, err := SafeJoin(base, rel)
+	if err != nil {
+		return "", err
+	}
+	if path == base {
+		return "", fmt.Errorf("folder name required")
+	}
+	return path, nil
+}
+
+// ErrInvalidRoot is returned when the root directory is missing or not a directory
+var ErrInvalidRoot = errors.New("invalid root directory")
+
+// ResolveRoot expands variables and a leading ~ in a root directory (see
+// ExpandPath), makes it absolute and checks that it is an existing directory
+func ResolveRoot(path string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", fmt.Errorf("%w: not specified", ErrInvalidRoot)
+	}
+
+	expanded, err := ExpandPath(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrInvalidRoot, path, err)
+	}
+	abs, err := filepath.Abs(expanded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidRoot, path, err)
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %s does not exist", ErrInvalidRoot, path)
 		}
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidRoot, path, err)
 	}
-	abs, err := filepath.Abs(path)
+	if !info.IsDir() {
+		return "", fmt.Errorf("%w: %s is not a directory", ErrInvalidRoot, path)
+	}
+	return abs, nil
+}
+
+// ErrPathEscapesRoot is returned when a path resolves outside the root directory
+var ErrPathEscapesRoot = errors.New("path escapes root directory")
+
+// MetaFileSuffix names the metadata sidecar written next to a downloaded
+// file. Sidecars are not user files: folders holding only sidecars count as
+// empty.
+const MetaFileSuffix = ".meta.json"
+
+// TrashFolder is the folder under a root directory that deleted files are
+// moved into. It is never listed as a download folder.
+const TrashFolder = ".trash"
+
+// SafeJoin joins parts onto the resolved root directory and verifies the
+// cleaned result is still inside it, so client-supplied folder and file
+// names like "../../etc" can't reach the rest of the filesystem.
+func SafeJoin(root string, parts ...string) (string, error) {
+	base, err := ResolveRoot(root)
 	if err != nil {
-		return path
+		return "", err
+	}
+	full := filepath.Join(append([]string{base}, parts...)...)
+
+	rel, err := filepath.Rel(base, full)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrPathEscapesRoot, strings.Join(parts, string(filepath.Separator)))
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscapesRoot, strings.Join(parts, string(filepath.Separator)))
 	}
-	return abs
+	return full, nil
+}
+
+// writeTempFile writes the temporary file in WriteFileAtomic; tests replace
+// it to simulate a write cut short by a full disk or a crash
+var writeTempFile = (*os.File).Write
+
+// WriteFileAtomic writes data to a temporary file in the same directory,
+// syncs it and renames it over path. A crash mid-write leaves the previous
+// contents of path intact instead of a truncated file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := writeTempFile(tmp, data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// CreateTemp always uses 0600
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// CleanFileName turns a file name suggested by a remote server into a plain
+// name: directory components are dropped and invalid characters replaced.
+// It returns "" when nothing usable is left, e.g. for "." or "..".
+func CleanFileName(name string) string {
+	// Either separator may come from the server, whatever the local OS
+	if idx := strings.LastIndexAny(name, `/\`); idx != -1 {
+		name = name[idx+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "." || name == ".." {
+		return ""
+	}
+	return sanitizeFileName(name)
+}
+
+// windowsNames drops trailing dots and spaces the way Windows does, which
+// other systems don't
+var windowsNames = runtime.GOOS == "windows"
+
+// reservedNames are Windows device names, reserved with any extension and
+// in any case. They're avoided on every system, since configs move between
+// machines and a name made on Linux must still work on Windows.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"COM¹": true, "COM²": true, "COM³": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"LPT¹": true, "LPT²": true, "LPT³": true,
 }
 
 func sanitizeFileName(name string) string {
@@ -192,6 +1025,38 @@ func sanitizeFileName(name string) string {
 		">", "_",
 		"|", "_",
 	)
-	return replacer.Replace(name)
+	name = truncateFileName(replacer.Replace(name), maxFileNameLength)
+
+	if windowsNames {
+		// Windows drops trailing dots and spaces, so "a." and "a" collide
+		name = strings.TrimRight(name, ". ")
+		if name == "" {
+			name = "_"
+		}
+	}
+	base, ext, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = base + "_"
+		if ext != "" {
+			name += "." + ext
+		}
+	}
+	return name
 }
 
+// truncateFileName shortens name to at most max bytes without splitting a
+// UTF-8 character, keeping a short extension
+func truncateFileName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	cut := max - len(ext)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + ext
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// fetchTimeout bounds the whole request made by FetchConfig
+	fetchTimeout = 15 * time.Second
+
+	// maxFetchSize is the largest config FetchConfig accepts
+	maxFetchSize = 4 << 20
+)
+
+// ErrFetchConfig is returned by FetchConfig when the URL can't be fetched
+var ErrFetchConfig = errors.New("failed to fetch config")
+
+// FetchConfig downloads a shared config from an http(s) URL with client,
+// which should guard against internal addresses the way downloads do. Only
+// JSON responses are accepted, including text/plain which raw file hosts
+// such as GitHub use for .json files. A response that isn't a valid config
+// wraps ErrInvalidConfig.
+func FetchConfig(ctx context.Context, client *http.Client, rawURL string) (*Config, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid URL: %v", ErrInvalidConfig, err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: URL must be http or https", ErrInvalidConfig)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchConfig, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: bad status: %s", ErrFetchConfig, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !isJSONType(ct) {
+		return nil, fmt.Errorf("%w: expected JSON, got content type %q", ErrInvalidConfig, ct)
+	}
+	if resp.ContentLength > maxFetchSize {
+		return nil, fmt.Errorf("%w: config is larger than %d bytes", ErrInvalidConfig, maxFetchSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchConfig, err)
+	}
+	if len(data) > maxFetchSize {
+		return nil, fmt.Errorf("%w: config is larger than %d bytes", ErrInvalidConfig, maxFetchSize)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", ErrInvalidConfig, err)
+	}
+	return &cfg, nil
+}
+
+// isJSONType reports whether a Content-Type header may hold a JSON config.
+// A missing header is allowed, the body still has to parse.
+func isJSONType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/json", "text/json", "text/plain":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,77 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"multy-loader/internal/config"
+	"multy-loader/internal/downloader"
+)
+
+func TestFetchConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":"shared","files":[]}`))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html></html>`))
+		case "/redirect":
+			http.Redirect(w, r, "/config.json", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	downloader.AllowInternalAddresses(true)
+	defer downloader.AllowInternalAddresses(false)
+	client := downloader.NewDownloader(downloader.DownloaderOptions{}).HTTPClient()
+
+	tests := []struct {
+		path    string
+		wantErr error
+	}{
+		{"/config.json", nil},
+		{"/redirect", nil},
+		{"/page.html", config.ErrInvalidConfig},
+		{"/missing", config.ErrFetchConfig},
+	}
+	for _, tt := range tests {
+		cfg, err := config.FetchConfig(context.Background(), client, srv.URL+tt.path)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got error %v, want %v", tt.path, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		} else if cfg.Name != "shared" {
+			t.Errorf("%s: got name %q", tt.path, cfg.Name)
+		}
+	}
+}
+
+func TestFetchConfigRefusesInternalAddresses(t *testing.T) {
+	fetched := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte(`{"name":"internal"}`))
+	}))
+	defer srv.Close()
+
+	downloader.AllowInternalAddresses(false)
+	client := downloader.NewDownloader(downloader.DownloaderOptions{}).HTTPClient()
+	_, err := config.FetchConfig(context.Background(), client, srv.URL)
+	if !errors.Is(err, downloader.ErrInternalAddress) {
+		t.Fatalf("got error %v, want ErrInternalAddress", err)
+	}
+	if fetched {
+		t.Fatal("internal server was reached")
+	}
+}
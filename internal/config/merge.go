@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// MergeReport describes what MergeConfigs did with the source entries
+type MergeReport struct {
+	Merged        int            `json:"merged"`                  // Entries copied into the new config
+	Skipped       int            `json:"skipped"`                 // Duplicates of an earlier entry
+	RootDirectory string         `json:"rootDirectory"`           // Root of the new config, taken from the first source
+	RootConflicts []RootConflict `json:"rootConflicts,omitempty"` // Sources whose root differs from RootDirectory
+}
+
+// RootConflict is a merged source with a different root directory
+type RootConflict struct {
+	Config        string `json:"config"`
+	RootDirectory string `json:"rootDirectory"`
+}
+
+// MergeConfigs combines the files of the named configs into a new config
+// called newName and saves it. An entry whose URL or ID was already seen is
+// skipped, so the first source listing a file wins. The new config uses the
+// first source's root directory and the first non-empty token, proxy and
+// webhook. Entries are resolved against their source's defaults first, so
+// they keep their folder and token setting; sources with another root are listed in the report rather than
+// silently merged into it.
+func (m *Manager) MergeConfigs(names []string, newName string) (*Config, *MergeReport, error) {
+	if len(names) < 2 {
+		return nil, nil, fmt.Errorf("%w: at least two configs are needed to merge", ErrInvalidConfig)
+	}
+	if newName == "" {
+		return nil, nil, fmt.Errorf("config name cannot be empty")
+	}
+
+	merged := &Config{Name: newName, Files: []FileEntry{}}
+	report := &MergeReport{}
+	seenURLs := make(map[string]bool)
+	seenIDs := make(map[string]bool)
+	for i, name := range names {
+		src, err := m.LoadConfig(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if i == 0 {
+			merged.RootDirectory = src.RootDirectory
+			report.RootDirectory = src.RootDirectory
+		} else if filepath.Clean(src.RootDirectory) != filepath.Clean(merged.RootDirectory) {
+			report.RootConflicts = append(report.RootConflicts, RootConflict{Config: name, RootDirectory: src.RootDirectory})
+		}
+		if merged.CivitaiToken == "" {
+			merged.CivitaiToken = src.CivitaiToken
+		}
+		if merged.HuggingFaceToken == "" {
+			merged.HuggingFaceToken = src.HuggingFaceToken
+		}
+		if merged.ProxyURL == "" {
+			merged.ProxyURL = src.ProxyURL
+		}
+		if merged.WebhookURL == "" {
+			merged.WebhookURL = src.WebhookURL
+		}
+
+		for _, entry := range src.ResolveAll(src.Files) {
+			if seenURLs[entry.URL] || (entry.ID != "" && seenIDs[entry.ID]) {
+				report.Skipped++
+				continue
+			}
+			seenURLs[entry.URL] = true
+			seenIDs[entry.ID] = true
+			merged.Files = append(merged.Files, entry)
+			report.Merged++
+		}
+	}
+
+	merged.AssignIDs(false)
+	if err := m.SaveConfig(merged); err != nil {
+		return nil, nil, err
+	}
+	return merged, report, nil
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ErrUndefinedVariable is returned when a folder or file name refers to a
+// variable that isn't set
+var ErrUndefinedVariable = errors.New("undefined variable")
+
+// pathVars are the built-in variables of folder and file names. Anything
+// else is looked up in the environment.
+var pathVars = map[string]func() (string, bool){
+	"os":   func() (string, bool) { return runtime.GOOS, true },
+	"arch": func() (string, bool) { return runtime.GOARCH, true },
+	"hostname": func() (string, bool) {
+		name, err := os.Hostname()
+		return name, err == nil && name != ""
+	},
+}
+
+// ExpandVars replaces ${NAME} in a folder or file name with the built-in
+// variable or environment variable NAME, so one config can serve machines
+// with different layouts. A "$" not followed by "{" is kept as is.
+func ExpandVars(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start == -1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("%w: unterminated variable in %q", ErrInvalidConfig, s)
+		}
+		name := s[start+2 : start+end]
+		value, ok := lookupVar(name)
+		if !ok {
+			return "", fmt.Errorf("%w: ${%s}", ErrUndefinedVariable, name)
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+// ExpandPath expands a root directory written for any platform: ${NAME} and
+// $NAME as on Unix, %NAME% as on Windows, then a leading ~ as the home
+// directory. Names are looked up like ExpandVars. The result isn't made
+// absolute. A "$" or "%" that doesn't start a variable is kept.
+func ExpandPath(path string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c != '$' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+
+		var name string
+		var end int // Index of the variable's last byte
+		switch {
+		case c == '$' && strings.HasPrefix(path[i:], "${"):
+			close := strings.IndexByte(path[i:], '}')
+			if close == -1 {
+				return "", fmt.Errorf("%w: unterminated variable in %q", ErrInvalidConfig, path)
+			}
+			name, end = path[i+2:i+close], i+close
+		case c == '$':
+			n := varNameLen(path[i+1:], false)
+			name, end = path[i+1:i+1+n], i+n
+		default:
+			n := varNameLen(path[i+1:], true)
+			if n == 0 || i+1+n >= len(path) || path[i+1+n] != '%' {
+				b.WriteByte(c)
+				continue
+			}
+			name, end = path[i+1:i+1+n], i+1+n
+		}
+		if name == "" {
+			b.WriteByte(c)
+			continue
+		}
+
+		value, ok := lookupVar(name)
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrUndefinedVariable, path[i:end+1])
+		}
+		b.WriteString(value)
+		i = end
+	}
+
+	expanded := b.String()
+	if expanded == "~" || strings.HasPrefix(expanded, "~/") || strings.HasPrefix(expanded, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		expanded = filepath.Join(home, expanded[1:])
+	}
+	return expanded, nil
+}
+
+// varNameLen returns the length of the variable name at the start of s:
+// a letter or underscore followed by letters, digits and underscores.
+// Windows names may also contain parentheses, as in ProgramFiles(x86).
+func varNameLen(s string, windows bool) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && '0' <= c && c <= '9':
+		case windows && i > 0 && (c == '(' || c == ')'):
+		default:
+			return i
+		}
+	}
+	return len(s)
+}
+
+func lookupVar(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	if fn, ok := pathVars[name]; ok {
+		return fn()
+	}
+	return os.LookupEnv(name)
+}
+
+// EntryPath expands the variables in an entry's folder and file name and
+// joins them onto root with SafeJoin
+func EntryPath(root, folder, fileName string) (string, error) {
+	folder, err := ExpandVars(folder)
+	if err != nil {
+		return "", err
+	}
+	fileName, err = ExpandVars(fileName)
+	if err != nil {
+		return "", err
+	}
+	return SafeJoin(root, folder, fileName)
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandPath(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home")
+	profile := filepath.Join(t.TempDir(), "profile")
+	// os.UserHomeDir reads HOME on Unix and USERPROFILE on Windows
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("ML_PROFILE", profile)
+	t.Setenv("ML_SUB", "sub")
+	t.Setenv("ProgramFiles(x86)", `C:\Program Files (x86)`)
+	t.Setenv("ML_UNDEFINED", "") // Restored after the test
+	os.Unsetenv("ML_UNDEFINED")
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr error
+	}{
+		{path: "$HOME/models", want: home + "/models"},
+		{path: "${HOME}/models", want: home + "/models"},
+		{path: "%USERPROFILE%/models", want: home + "/models"},
+		{path: `%ML_PROFILE%\models`, want: profile + `\models`},
+		{path: "$ML_PROFILE/$ML_SUB", want: profile + "/sub"},
+		{path: "${ML_PROFILE}${ML_SUB}", want: profile + "sub"},
+		{path: `%ProgramFiles(x86)%\models`, want: `C:\Program Files (x86)\models`},
+		{path: "${os}/models", want: runtime.GOOS + "/models"},
+		{path: "~", want: home},
+		{path: "~/models", want: filepath.Join(home, "models")},
+		{path: "~user/models", want: "~user/models"},
+		{path: "/data/~/models", want: "/data/~/models"},
+		{path: `D:\models\100%`, want: `D:\models\100%`},
+		{path: "/data/50% off", want: "/data/50% off"},
+		{path: "/data/$5/$", want: "/data/$5/$"},
+		{path: "/data/models", want: "/data/models"},
+		{path: "$ML_UNDEFINED/models", wantErr: ErrUndefinedVariable},
+		{path: "${ML_UNDEFINED}/models", wantErr: ErrUndefinedVariable},
+		{path: "%ML_UNDEFINED%/models", wantErr: ErrUndefinedVariable},
+		{path: "${ML_PROFILE/models", wantErr: ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		got, err := ExpandPath(tt.path)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ExpandPath(%q) = %q, %v, want error %v", tt.path, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveRoot(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "file.txt")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ML_ROOT", root)
+	t.Setenv("HOME", root)
+	t.Setenv("USERPROFILE", root)
+	t.Setenv("ML_UNDEFINED", "") // Restored after the test
+	os.Unsetenv("ML_UNDEFINED")
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr []error
+	}{
+		{path: "$ML_ROOT", want: root},
+		{path: "%ML_ROOT%", want: root},
+		{path: "~", want: root},
+		{path: "${ML_ROOT}/../" + filepath.Base(root), want: root},
+		{path: ".", want: cwd},
+		{path: "", wantErr: []error{ErrInvalidRoot}},
+		{path: "  ", wantErr: []error{ErrInvalidRoot}},
+		{path: "$ML_UNDEFINED/models", wantErr: []error{ErrInvalidRoot, ErrUndefinedVariable}},
+		{path: "%ML_UNDEFINED%", wantErr: []error{ErrInvalidRoot, ErrUndefinedVariable}},
+		{path: "$ML_ROOT/missing", wantErr: []error{ErrInvalidRoot}},
+		{path: "$ML_ROOT/file.txt", wantErr: []error{ErrInvalidRoot}},
+	}
+	for _, tt := range tests {
+		got, err := ResolveRoot(tt.path)
+		if tt.wantErr != nil {
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("ResolveRoot(%q) = %q, %v, want error %v", tt.path, got, err, want)
+				}
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveRoot(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Severities of a ValidationIssue
+const (
+	SeverityError   = "error"   // The config can't be saved
+	SeverityWarning = "warning" // Saved, but probably not what was meant
+)
+
+// ValidationIssue is one problem found by Validate
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Index    int    `json:"index"`            // Position of the entry in Files, -1 for config-level issues
+	FileID   string `json:"fileId,omitempty"` // ID of the entry, if it has one
+	Field    string `json:"field"`            // JSON name of the offending field
+	Message  string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	if i.Index < 0 {
+		return i.Message
+	}
+	if i.FileID != "" {
+		return fmt.Sprintf("entry %d (%s): %s", i.Index, i.FileID, i.Message)
+	}
+	return fmt.Sprintf("entry %d: %s", i.Index, i.Message)
+}
+
+// Validate checks a config without saving it. Errors are what SaveConfig
+// rejects: a missing name, an invalid webhook URL, entries without a usable
+// http(s) URL (placeholders without one need a title), duplicate entry IDs,
+// since progress is tracked by ID, and folders or file names that would
+// leave the root directory. Warnings point out things that are saved but
+// likely mistakes, such as entries that would download to the same path
+// and overwrite each other.
+func Validate(c *Config) []ValidationIssue {
+	issues := []ValidationIssue{}
+	add := func(severity string, index int, id, field, format string, args ...any) {
+		issues = append(issues, ValidationIssue{
+			Severity: severity,
+			Index:    index,
+			FileID:   id,
+			Field:    field,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if c.Name == "" {
+		add(SeverityError, -1, "", "name", "config name is required")
+	}
+	if c.RootDirectory == "" {
+		add(SeverityWarning, -1, "", "rootDirectory", "no root directory set")
+	}
+	if c.WebhookURL != "" && !isDownloadURL(c.WebhookURL) {
+		add(SeverityError, -1, "", "webhookUrl", "invalid webhook URL")
+	}
+	if escapesRoot(c.DefaultFolder) {
+		add(SeverityError, -1, "", "defaultFolder", "default folder is outside the root directory")
+	}
+
+	seen := make(map[string]int)
+	destinations := make(map[string]int) // Cleaned folder/file name, with the default folder applied
+	for i, f := range c.Files {
+		if f.ID == "" {
+			add(SeverityWarning, i, "", "id", "no ID, one is assigned when the config is saved")
+		} else if first, ok := seen[f.ID]; ok {
+			add(SeverityError, i, f.ID, "id", "duplicate ID, also used by entry %d", first)
+		} else {
+			seen[f.ID] = i
+		}
+
+		if f.URL == "" {
+			if f.Title == "" {
+				add(SeverityError, i, f.ID, "url", "no URL, placeholders without one need a title")
+			}
+		} else if !isDownloadURL(f.URL) {
+			add(SeverityError, i, f.ID, "url", "invalid URL, expected http or https")
+		} else if f.FileName == "" {
+			add(SeverityWarning, i, f.ID, "fileName", "no file name, it is only downloaded with autoName")
+		}
+
+		if escapesRoot(f.Folder, f.FileName) {
+			add(SeverityError, i, f.ID, "folder", "path is outside the root directory")
+		}
+		if f.FileName != "" {
+			dest := filepath.Join(c.Resolve(f).Folder, f.FileName)
+			if first, ok := destinations[dest]; ok {
+				add(SeverityWarning, i, f.ID, "fileName", "same destination as entry %d (%s), one download overwrites the other", first, c.Files[first].ID)
+			} else {
+				destinations[dest] = i
+			}
+		}
+		if f.SHA256 != "" {
+			if b, err := hex.DecodeString(f.SHA256); err != nil || len(b) != 32 {
+				add(SeverityWarning, i, f.ID, "sha256", "not a SHA256 checksum, expected 64 hex characters")
+			}
+		}
+	}
+	return issues
+}
+
+// ValidateDownload checks entries about to be downloaded, returning an
+// error-level issue for each one that can't start: no usable http(s) URL,
+// no file name without autoName, or a path outside the root directory.
+// Entries are expected to have the config defaults applied already.
+func ValidateDownload(files []FileEntry, autoName bool) []ValidationIssue {
+	var issues []ValidationIssue
+	add := func(index int, f FileEntry, field, message string) {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Index:    index,
+			FileID:   f.ID,
+			Field:    field,
+			Message:  message,
+		})
+	}
+
+	for i, f := range files {
+		switch {
+		case f.URL == "":
+			add(i, f, "url", "no URL")
+		case !isDownloadURL(f.URL):
+			add(i, f, "url", "invalid URL, expected http or https")
+		}
+		if f.FileName == "" && !autoName {
+			add(i, f, "fileName", "no file name, set one or enable autoName")
+		}
+		if escapesRoot(f.Folder, f.FileName) {
+			add(i, f, "folder", "path is outside the root directory")
+		}
+	}
+	return issues
+}
+
+// escapesRoot reports whether joining parts onto any root directory would
+// leave it, the check SafeJoin makes once the root is known
+func escapesRoot(parts ...string) bool {
+	base := string(filepath.Separator) + "root"
+	rel, err := filepath.Rel(base, filepath.Join(append([]string{base}, parts...)...))
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigEvent reports a config file created, modified or deleted, whether
+// through the API or by editing the configs directory directly
+type ConfigEvent struct {
+	Type   string `json:"type"` // Always "config"
+	Name   string `json:"name"`
+	Change string `json:"change"` // "created", "modified" or "deleted"
+}
+
+// configWatchers are the subscribers of a Manager's config events
+type configWatchers struct {
+	mu        sync.Mutex
+	listeners []chan ConfigEvent
+}
+
+// SubscribeChanges returns a channel receiving an event for each config
+// change Watch detects
+func (m *Manager) SubscribeChanges() chan ConfigEvent {
+	m.watchers.mu.Lock()
+	defer m.watchers.mu.Unlock()
+	ch := make(chan ConfigEvent, 10)
+	m.watchers.listeners = append(m.watchers.listeners, ch)
+	return ch
+}
+
+// UnsubscribeChanges stops and closes a channel from SubscribeChanges
+func (m *Manager) UnsubscribeChanges(ch chan ConfigEvent) {
+	m.watchers.mu.Lock()
+	defer m.watchers.mu.Unlock()
+	for i, l := range m.watchers.listeners {
+		if l == ch {
+			m.watchers.listeners = append(m.watchers.listeners[:i], m.watchers.listeners[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+}
+
+func (m *Manager) broadcastChange(e ConfigEvent) {
+	m.watchers.mu.Lock()
+	defer m.watchers.mu.Unlock()
+	for _, ch := range m.watchers.listeners {
+		select {
+		case ch <- e:
+		default:
+			// Subscriber isn't keeping up, it reloads on the next event anyway
+		}
+	}
+}
+
+// Watch polls the configs directory every interval until ctx is done and
+// broadcasts a ConfigEvent for each config file that appears, changes or
+// disappears. A change is only reported once the file has stayed the same
+// for a full interval, so editors that write a file twice produce a single
+// event. Files are compared by modification time and size, and by content
+// while that isn't enough, see fileStamp.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := m.snapshot()
+	if reported == nil {
+		reported = make(map[string]fileStamp)
+	}
+	pending := make(map[string]fileStamp) // Changed since reported, waiting to settle
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := m.snapshot(reported, pending)
+		if current == nil {
+			continue // Unreadable for now, don't report everything as deleted
+		}
+		for name := range union(reported, current) {
+			stamp, exists := current[name]
+			if old, ok := reported[name]; ok && exists && old.matches(stamp) {
+				reported[name] = stamp // No longer racy, once old enough
+				delete(pending, name)  // Changed back, or never changed
+				continue
+			}
+			if last, ok := pending[name]; !ok || !last.matches(stamp) {
+				pending[name] = stamp // Still changing
+				continue
+			}
+			delete(pending, name)
+
+			change := "modified"
+			if _, ok := reported[name]; !ok {
+				change = "created"
+			} else if !exists {
+				change = "deleted"
+			}
+			if exists {
+				reported[name] = stamp
+			} else {
+				delete(reported, name)
+			}
+			m.broadcastChange(ConfigEvent{Type: "config", Name: name, Change: change})
+		}
+	}
+}
+
+// snapshot returns the stamp of every config file by config name, or nil
+// if the directory can't be read. A deleted file is represented by its
+// absence. Files are read for their sum when racy, or when they'll be
+// compared with a racy stamp in one of prev.
+func (m *Manager) snapshot(prev ...map[string]fileStamp) map[string]fileStamp {
+	entries, err := os.ReadDir(m.configsDir)
+	if err != nil {
+		return nil
+	}
+	stamps := make(map[string]fileStamp)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		stamp := stampOf(info)
+		if stamp.racy || racyIn(name, prev) {
+			// Unreadable leaves the sum unset, which reads as a change
+			if data, err := os.ReadFile(filepath.Join(m.configsDir, entry.Name())); err == nil {
+				stamp = stamp.withContents(data)
+			}
+		}
+		stamps[name] = stamp
+	}
+	return stamps
+}
+
+// racyIn reports whether any of stamps has a racy stamp for name
+func racyIn(name string, stamps []map[string]fileStamp) bool {
+	for _, s := range stamps {
+		if s[name].racy {
+			return true
+		}
+	}
+	return false
+}
+
+// union returns the keys of both maps
+func union(a, b map[string]fileStamp) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
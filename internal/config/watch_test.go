@@ -0,0 +1,88 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next config event, failing after a timeout
+func nextEvent(t *testing.T, ch chan ConfigEvent) ConfigEvent {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no config event")
+		return ConfigEvent{}
+	}
+}
+
+// noEvent fails if a config event arrives within d
+func noEvent(t *testing.T, ch chan ConfigEvent, d time.Duration) {
+	t.Helper()
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event %+v", e)
+	case <-time.After(d):
+	}
+}
+
+func TestWatchSeesSameStampEdit(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "models.json")
+	if err := os.WriteFile(path, []byte(`{"name": "aaaaaa"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ch := m.SubscribeChanges()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Watch(ctx, 20*time.Millisecond)
+	noEvent(t, ch, 100*time.Millisecond) // Watch has taken its first snapshot
+
+	rewriteKeepingStamp(t, path, []byte(`{"name": "bbbbbb"}`))
+	if e := nextEvent(t, ch); e.Name != "models" || e.Change != "modified" {
+		t.Fatalf("got %+v, want models modified", e)
+	}
+
+	os.Remove(path)
+	if e := nextEvent(t, ch); e.Name != "models" || e.Change != "deleted" {
+		t.Fatalf("got %+v, want models deleted", e)
+	}
+}
+
+func TestWatchRacyStampSettlesQuietly(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stops being racy a moment after the watch starts
+	path := filepath.Join(dir, "models.json")
+	if err := os.WriteFile(path, []byte(`{"name": "models"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	almostSettled := time.Now().Add(-stampGranularity + 100*time.Millisecond)
+	if err := os.Chtimes(path, almostSettled, almostSettled); err != nil {
+		t.Fatal(err)
+	}
+	ch := m.SubscribeChanges()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Watch(ctx, 20*time.Millisecond)
+	noEvent(t, ch, 300*time.Millisecond)
+
+	// Settled stamps still see ordinary edits
+	if err := os.WriteFile(path, []byte(`{"name": "renamed"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, ch); e.Change != "modified" {
+		t.Fatalf("got %+v, want modified", e)
+	}
+}
@@ -0,0 +1,419 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"multy-loader/internal/config"
+)
+
+// IsArchive checks if file is an archive based on extension. .7z and .rar
+// are included, though extracting them needs an external tool.
+func IsArchive(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	return externalFormat(lower) != "" ||
+		strings.HasSuffix(lower, ".zip") ||
+		strings.HasSuffix(lower, ".tar") ||
+		strings.HasSuffix(lower, ".tar.gz") ||
+		strings.HasSuffix(lower, ".tgz") ||
+		strings.HasSuffix(lower, ".tar.bz2") ||
+		strings.HasSuffix(lower, ".tbz2")
+}
+
+// ErrArchiveEntryNotFound is returned when a requested entry isn't in the archive
+var ErrArchiveEntryNotFound = errors.New("entry not found in archive")
+
+// ExtractedFileInfo contains info about extracted file
+type ExtractedFileInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// ExtractArchive extracts an archive and returns list of extracted files with sizes.
+// Progress is broadcast to subscribers under progressID (defaults to the file name).
+// When entries is non-empty only those files are written; each must be a file
+// name as ListArchive reports it.
+func (d *Downloader) ExtractArchive(rootDir, folder, fileName, progressID string, entries []string) ([]ExtractedFileInfo, error) {
+	archivePath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return nil, err
+	}
+	folder, err = config.ExpandVars(folder)
+	if err != nil {
+		return nil, err
+	}
+	extractDir, err := config.SafeJoin(rootDir, folder)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := d.selectEntries(rootDir, folder, fileName, extractDir, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if progressID == "" {
+		progressID = fileName
+	}
+	progress := &extractProgress{
+		d: d,
+		p: Progress{
+			FileID:     progressID,
+			FileName:   fileName,
+			ETASeconds: -1,
+			Status:     "extracting",
+		},
+	}
+	progress.update(func(p *Progress) {})
+
+	extracted, err := extractByFormat(archivePath, extractDir, fileName, selected, progress.update)
+	if err != nil {
+		progress.update(func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return extracted, err
+	}
+
+	progress.update(func(p *Progress) {
+		p.Status = "completed"
+		p.Percent = 100
+		p.Downloaded = p.Total
+		p.ETASeconds = 0
+	})
+	return extracted, nil
+}
+
+// extractProgress publishes extraction progress to subscribers. Unlike
+// downloads it isn't kept in the progress map.
+type extractProgress struct {
+	d  *Downloader
+	mu sync.Mutex
+	p  Progress
+}
+
+func (e *extractProgress) update(fn func(p *Progress)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn(&e.p)
+	e.d.broadcast(e.p)
+}
+
+// entrySet holds the archive entries to extract. A nil set extracts everything.
+type entrySet map[string]bool
+
+func (s entrySet) has(name string) bool {
+	return s == nil || s[name]
+}
+
+// selectEntries checks that every requested entry is a file in the archive
+// with a safe destination, and returns them as a set. No entries means the
+// whole archive, returned as a nil set.
+func (d *Downloader) selectEntries(rootDir, folder, fileName, extractDir string, entries []string) (entrySet, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	files, err := d.ListArchive(rootDir, folder, fileName)
+	if err != nil {
+		return nil, err
+	}
+	inArchive := make(map[string]bool, len(files))
+	for _, f := range files {
+		inArchive[f.Name] = true
+	}
+
+	selected := make(entrySet, len(entries))
+	for _, name := range entries {
+		if !inArchive[name] {
+			return nil, fmt.Errorf("%w: %s", ErrArchiveEntryNotFound, name)
+		}
+		// Security: prevent path traversal
+		if _, err := archiveEntryPath(extractDir, name); err != nil {
+			return nil, fmt.Errorf("%w: %v", config.ErrPathEscapesRoot, err)
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+func extractByFormat(archivePath, extractDir, fileName string, selected entrySet, update func(fn func(p *Progress))) ([]ExtractedFileInfo, error) {
+	lower := strings.ToLower(fileName)
+
+	if strings.HasSuffix(lower, ".zip") {
+		return extractZip(archivePath, extractDir, selected, update)
+	}
+
+	if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+		return extractTarGz(archivePath, extractDir, selected, update)
+	}
+
+	if strings.HasSuffix(lower, ".tar.bz2") || strings.HasSuffix(lower, ".tbz2") {
+		return extractTarBz2(archivePath, extractDir, selected, update)
+	}
+
+	if strings.HasSuffix(lower, ".tar") {
+		return extractTar(archivePath, extractDir, selected, update)
+	}
+
+	if format := externalFormat(lower); format != "" {
+		return extractExternal(archivePath, extractDir, format, selected, update)
+	}
+
+	return nil, ErrUnsupportedArchive
+}
+
+// DeleteExtractedFile deletes an extracted file from disk
+func (d *Downloader) DeleteExtractedFile(rootDir, folder, fileName string) error {
+	fullPath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fullPath)
+}
+
+// archiveEntryPath validates an archive entry name and returns its destination
+// inside extractDir. Absolute names and names escaping extractDir are rejected.
+func archiveEntryPath(extractDir, name string) (string, error) {
+	// Archives created on Windows may use backslashes
+	clean := filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(clean, string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry has absolute path: %s", name)
+	}
+
+	destPath, err := config.SafeJoin(extractDir, clean)
+	if err != nil {
+		return "", fmt.Errorf("archive entry escapes destination folder: %s", name)
+	}
+	return destPath, nil
+}
+
+func extractZip(archivePath, extractDir string, selected entrySet, update func(fn func(p *Progress))) ([]ExtractedFileInfo, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+	defer r.Close()
+
+	// The central directory lists every uncompressed size up front
+	var total int64
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && selected.has(f.Name) {
+			total += int64(f.UncompressedSize64)
+		}
+	}
+	update(func(p *Progress) {
+		p.Total = total
+	})
+	tracker := newProgressTracker(total, update)
+
+	var extracted []ExtractedFileInfo
+
+	for _, f := range r.File {
+		if !selected.has(f.Name) {
+			continue
+		}
+
+		// Security: prevent path traversal
+		destPath, err := archiveEntryPath(extractDir, f.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			return extracted, fmt.Errorf("archive contains symlink entry: %s", f.Name)
+		}
+
+		// Create directories but don't list them as extracted files
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+
+		// Create directory structure
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return extracted, err
+		}
+
+		// Extract file
+		rc, err := f.Open()
+		if err != nil {
+			return extracted, err
+		}
+
+		outFile, err := os.Create(destPath)
+		if err != nil {
+			rc.Close()
+			return extracted, err
+		}
+
+		written, err := copyWithProgress(context.Background(), outFile, rc, tracker)
+		outFile.Close()
+		rc.Close()
+
+		if err != nil {
+			return extracted, err
+		}
+
+		extracted = append(extracted, ExtractedFileInfo{
+			Name: f.Name,
+			Size: written,
+		})
+	}
+
+	return extracted, nil
+}
+
+// Compressed tars have no index, so their progress follows how much of the
+// compressed file has been consumed rather than decompressing twice.
+
+func extractTarGz(archivePath, extractDir string, selected entrySet, update func(fn func(p *Progress))) ([]ExtractedFileInfo, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	gzr, err := gzip.NewReader(compressedProgressReader(file, update))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzr.Close()
+
+	return extractTarReader(tar.NewReader(gzr), extractDir, selected, nil)
+}
+
+func extractTarBz2(archivePath, extractDir string, selected entrySet, update func(fn func(p *Progress))) ([]ExtractedFileInfo, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return extractTarReader(tar.NewReader(bzip2.NewReader(compressedProgressReader(file, update))), extractDir, selected, nil)
+}
+
+// compressedProgressReader tracks progress against the compressed file size
+func compressedProgressReader(file *os.File, update func(fn func(p *Progress))) io.Reader {
+	var total int64
+	if info, err := file.Stat(); err == nil {
+		total = info.Size()
+	}
+	update(func(p *Progress) {
+		p.Total = total
+	})
+	return &progressReader{r: file, tracker: newProgressTracker(total, update)}
+}
+
+func extractTar(archivePath, extractDir string, selected entrySet, update func(fn func(p *Progress))) ([]ExtractedFileInfo, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Sum entry sizes from the headers first; the tar reader seeks over the data
+	var total int64
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && selected.has(header.Name) {
+			total += header.Size
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind archive: %w", err)
+	}
+	update(func(p *Progress) {
+		p.Total = total
+	})
+
+	return extractTarReader(tar.NewReader(file), extractDir, selected, newProgressTracker(total, update))
+}
+
+// extractTarReader writes the selected entries of tr under extractDir. When
+// tracker is non-nil, extracted bytes are reported to it.
+func extractTarReader(tr *tar.Reader, extractDir string, selected entrySet, tracker *progressTracker) ([]ExtractedFileInfo, error) {
+	var extracted []ExtractedFileInfo
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, err
+		}
+		if !selected.has(header.Name) {
+			continue
+		}
+
+		// Security: prevent path traversal
+		destPath, err := archiveEntryPath(extractDir, header.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			return extracted, fmt.Errorf("archive contains link entry: %s", header.Name)
+		}
+
+		// Create directories but don't list them as extracted files
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+
+		// Only regular files carry data
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Create directory structure
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return extracted, err
+		}
+
+		// Extract file
+		outFile, err := os.Create(destPath)
+		if err != nil {
+			return extracted, err
+		}
+
+		var written int64
+		if tracker != nil {
+			written, err = copyWithProgress(context.Background(), outFile, tr, tracker)
+		} else {
+			written, err = io.Copy(outFile, tr)
+		}
+		outFile.Close()
+
+		if err != nil {
+			return extracted, err
+		}
+
+		extracted = append(extracted, ExtractedFileInfo{
+			Name: header.Name,
+			Size: written,
+		})
+	}
+
+	return extracted, nil
+}
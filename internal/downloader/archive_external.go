@@ -0,0 +1,308 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedArchive is returned for archives no extractor is available for
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// externalExtractor runs a command line tool to unpack or list an archive
+type externalExtractor struct {
+	tool  string
+	args  func(archive, dir string, names []string) []string // Extracts archive (or just names) into dir
+	list  func(archive string) []string                      // Lists archive with file sizes
+	parse func(listing string) []ExtractedFileInfo           // Reads the regular files from list's output
+}
+
+var (
+	sevenZip = externalExtractor{
+		args: func(archive, dir string, names []string) []string {
+			return append([]string{"x", "-y", "-o" + dir, "--", archive}, names...)
+		},
+		list: func(archive string) []string {
+			return []string{"l", "-slt", "--", archive}
+		},
+		parse: parseSevenZipListing,
+	}
+	unrar = externalExtractor{
+		tool: "unrar",
+		args: func(archive, dir string, names []string) []string {
+			args := append([]string{"x", "-y", "-o+", "--", archive}, names...)
+			return append(args, dir+string(filepath.Separator))
+		},
+		list: func(archive string) []string {
+			return []string{"lt", "--", archive}
+		},
+		parse: parseUnrarListing,
+	}
+	bsdtar = externalExtractor{
+		tool: "bsdtar",
+		args: func(archive, dir string, names []string) []string {
+			return append([]string{"-x", "-f", archive, "-C", dir, "--"}, names...)
+		},
+		list: func(archive string) []string {
+			return []string{"-t", "-v", "-f", archive}
+		},
+		parse: parseBsdtarListing,
+	}
+)
+
+// withTool returns e run as the given binary, for tools with several names
+func (e externalExtractor) withTool(tool string) externalExtractor {
+	e.tool = tool
+	return e
+}
+
+// externalExtractors lists the tools tried for formats the standard library
+// can't read, in order of preference. bsdtar (libarchive) reads both.
+var externalExtractors = map[string][]externalExtractor{
+	".7z":  {sevenZip.withTool("7zz"), sevenZip.withTool("7z"), sevenZip.withTool("7za"), bsdtar},
+	".rar": {unrar, sevenZip.withTool("7zz"), sevenZip.withTool("7z"), bsdtar},
+}
+
+// externalFormat returns the extension of an archive that needs an
+// external tool, or "" for other files
+func externalFormat(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if _, ok := externalExtractors[ext]; ok {
+		return ext
+	}
+	return ""
+}
+
+// findExtractor returns the first tool on PATH that can unpack format
+func findExtractor(format string) (externalExtractor, string, bool) {
+	for _, e := range externalExtractors[format] {
+		if path, err := exec.LookPath(e.tool); err == nil {
+			return e, path, true
+		}
+	}
+	return externalExtractor{}, "", false
+}
+
+// extractExternal unpacks a .7z or .rar archive with whichever tool is
+// installed. The tool writes into a staging folder, and only regular files
+// that stay inside extractDir are moved into place, so links and escaping
+// names get the same protection as zip and tar entries.
+func extractExternal(archivePath, extractDir, format string, selected entrySet, update func(fn func(p *Progress))) ([]ExtractedFileInfo, error) {
+	e, toolPath, err := requireExtractor(format)
+	if err != nil {
+		return nil, err
+	}
+
+	// There are no per-file sizes up front, progress only shows the archive size
+	if info, err := os.Stat(archivePath); err == nil {
+		update(func(p *Progress) {
+			p.Total = info.Size()
+		})
+	}
+
+	if err := os.MkdirAll(extractDir, 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(extractDir, ".extract-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging folder: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, err := runTool(e.tool, toolPath, e.args(archivePath, staging, names)); err != nil {
+		return nil, err
+	}
+
+	var extracted []ExtractedFileInfo
+	err = filepath.WalkDir(staging, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == staging {
+			return nil
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		// Folders are only created for the selected files inside them
+		if selected != nil && (entry.IsDir() || !selected.has(name)) {
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("archive contains link entry: %s", name)
+		}
+
+		// Security: prevent path traversal
+		destPath, err := archiveEntryPath(extractDir, rel)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(destPath, 0755)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, destPath); err != nil {
+			return err
+		}
+		extracted = append(extracted, ExtractedFileInfo{Name: name, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return extracted, err
+	}
+	return extracted, nil
+}
+
+// listExternal lists a .7z or .rar archive with whichever tool is installed
+func listExternal(archivePath, format string) ([]ExtractedFileInfo, error) {
+	e, toolPath, err := requireExtractor(format)
+	if err != nil {
+		return nil, err
+	}
+	out, err := runTool(e.tool, toolPath, e.list(archivePath))
+	if err != nil {
+		return nil, err
+	}
+	return e.parse(string(out)), nil
+}
+
+// requireExtractor is findExtractor with an error naming the missing tools
+func requireExtractor(format string) (externalExtractor, string, error) {
+	if e, path, ok := findExtractor(format); ok {
+		return e, path, nil
+	}
+	var tools []string
+	for _, e := range externalExtractors[format] {
+		tools = append(tools, e.tool)
+	}
+	return externalExtractor{}, "", fmt.Errorf("%w: %s needs one of %s installed", ErrUnsupportedArchive, format, strings.Join(tools, ", "))
+}
+
+// runTool runs an extractor and returns its standard output. Errors carry
+// the last line the tool printed, where they put their summary.
+func runTool(tool, path string, args []string) ([]byte, error) {
+	cmd := exec.Command(path, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String() + "\n" + string(out))
+		if i := strings.LastIndexByte(msg, '\n'); i != -1 {
+			msg = msg[i+1:]
+		}
+		return nil, fmt.Errorf("%s failed: %v: %s", tool, err, msg)
+	}
+	return out, nil
+}
+
+// parseSevenZipListing reads "7z l -slt" output: one "Key = value" block
+// per entry after a dashed line, the blocks before it describe the archive
+func parseSevenZipListing(listing string) []ExtractedFileInfo {
+	var files []ExtractedFileInfo
+	var path, attrs string
+	var size int64
+	inEntries := false
+	flush := func() {
+		// Directories have a D attribute, links an l in the unix mode
+		if path != "" && !strings.Contains(attrs, "D") && !strings.Contains(attrs, " l") {
+			files = append(files, ExtractedFileInfo{Name: filepath.ToSlash(path), Size: size})
+		}
+		path, attrs, size = "", "", 0
+	}
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "----------") {
+			inEntries = true
+			continue
+		}
+		if !inEntries {
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		switch {
+		case line == "":
+			flush()
+		case !ok:
+		case key == "Path":
+			path = value
+		case key == "Size":
+			size, _ = strconv.ParseInt(value, 10, 64)
+		case key == "Attributes":
+			attrs = value
+		}
+	}
+	flush()
+	return files
+}
+
+// parseUnrarListing reads "unrar lt" output: indented "Key: value" lines,
+// each entry starting with its Name
+func parseUnrarListing(listing string) []ExtractedFileInfo {
+	var files []ExtractedFileInfo
+	var name, kind string
+	var size int64
+	flush := func() {
+		if name != "" && kind == "File" {
+			files = append(files, ExtractedFileInfo{Name: filepath.ToSlash(name), Size: size})
+		}
+		name, kind, size = "", "", 0
+	}
+	for _, line := range strings.Split(listing, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Name":
+			flush()
+			name = value
+		case "Type":
+			kind = value
+		case "Size":
+			size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	flush()
+	return files
+}
+
+// parseBsdtarListing reads "bsdtar -tv" output, which looks like "ls -l":
+// mode, links, owner, group, size, three date fields, then the name
+func parseBsdtarListing(listing string) []ExtractedFileInfo {
+	var files []ExtractedFileInfo
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "-") {
+			continue // Directories, links and anything unexpected
+		}
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, ExtractedFileInfo{Name: strings.Join(fields[8:], " "), Size: size})
+	}
+	return files
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"multy-loader/internal/config"
+)
+
+// ListArchive returns the files an archive would extract to, with their
+// uncompressed sizes, without writing anything. Zip archives are read from
+// their central directory; tars from their headers, which for compressed
+// tars still means decompressing the stream. Directories and links aren't
+// listed.
+func (d *Downloader) ListArchive(rootDir, folder, fileName string) ([]ExtractedFileInfo, error) {
+	archivePath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return listZip(archivePath)
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		return listTarFile(archivePath, func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		})
+	case strings.HasSuffix(lower, ".tar.bz2") || strings.HasSuffix(lower, ".tbz2"):
+		return listTarFile(archivePath, func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		})
+	case strings.HasSuffix(lower, ".tar"):
+		return listTarFile(archivePath, func(r io.Reader) (io.Reader, error) {
+			return r, nil
+		})
+	}
+	if format := externalFormat(lower); format != "" {
+		if _, err := os.Stat(archivePath); err != nil {
+			return nil, err
+		}
+		return listExternal(archivePath, format)
+	}
+	return nil, ErrUnsupportedArchive
+}
+
+func listZip(archivePath string) ([]ExtractedFileInfo, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+	defer r.Close()
+
+	files := []ExtractedFileInfo{}
+	for _, f := range r.File {
+		if f.Mode().IsRegular() {
+			files = append(files, ExtractedFileInfo{Name: f.Name, Size: int64(f.UncompressedSize64)})
+		}
+	}
+	return files, nil
+}
+
+// listTarFile lists a tar archive, after decompress wraps the file reader
+func listTarFile(archivePath string, decompress func(io.Reader) (io.Reader, error)) ([]ExtractedFileInfo, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	r, err := decompress(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	files := []ExtractedFileInfo{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			files = append(files, ExtractedFileInfo{Name: header.Name, Size: header.Size})
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is one member of an archive built by a test
+type archiveEntry struct {
+	name     string
+	body     string
+	symlink  string // Link target, makes the entry a symlink
+	hardlink string // Link target, makes the entry a hard link (tar only)
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		if e.symlink != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			body = e.symlink
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.symlink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.symlink, 0
+		case e.hardlink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, e.hardlink, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tw.Write([]byte(e.body))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// filesOutside returns the files and links under base that aren't inside
+// dir or the archive itself
+func filesOutside(t *testing.T, base, dir, archive string) []string {
+	t.Helper()
+	var outside []string
+	filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || path == archive {
+			return nil
+		}
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			outside = append(outside, path)
+		}
+		return nil
+	})
+	return outside
+}
+
+func TestExtractArchiveRejectsEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr bool
+	}{
+		{"parent traversal", []archiveEntry{{name: "../../x", body: "evil"}}, true},
+		{"nested traversal", []archiveEntry{{name: "a/../../../x", body: "evil"}}, true},
+		{"backslash traversal", []archiveEntry{{name: `..\..\x`, body: "evil"}}, true},
+		{"absolute path", []archiveEntry{{name: "/tmp/x", body: "evil"}}, true},
+		{"symlink entry", []archiveEntry{{name: "link", symlink: "/etc/passwd"}}, true},
+		{"symlink then write through it", []archiveEntry{{name: "link", symlink: ".."}, {name: "link/x", body: "evil"}}, true},
+		{"good entry before a bad one", []archiveEntry{{name: "ok.txt", body: "fine"}, {name: "../x", body: "evil"}}, true},
+		{"safe entries", []archiveEntry{{name: "dir/ok.txt", body: "fine"}, {name: "dir/../top.txt", body: "fine"}}, false},
+	}
+
+	formats := []struct {
+		ext   string
+		build func(*testing.T, []archiveEntry) []byte
+	}{
+		{".zip", buildZip},
+		{".tar.gz", buildTarGz},
+	}
+
+	for _, format := range formats {
+		for _, tt := range tests {
+			t.Run(format.ext+"/"+tt.name, func(t *testing.T) {
+				base := t.TempDir()
+				root := filepath.Join(base, "root")
+				extractDir := filepath.Join(root, "models")
+				if err := os.MkdirAll(extractDir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				archive := filepath.Join(extractDir, "test"+format.ext)
+				if err := os.WriteFile(archive, format.build(t, tt.entries), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				d := NewDownloader(DownloaderOptions{})
+				_, err := d.ExtractArchive(root, "models", "test"+format.ext, "", nil)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+				}
+				if outside := filesOutside(t, base, extractDir, archive); len(outside) > 0 {
+					t.Fatalf("files written outside the extract folder: %v", outside)
+				}
+			})
+		}
+	}
+}
+
+func TestExtractTarRejectsHardLinks(t *testing.T) {
+	base := t.TempDir()
+	secret := filepath.Join(base, "secret")
+	os.WriteFile(secret, []byte("secret"), 0600)
+	extractDir := filepath.Join(base, "models")
+	os.MkdirAll(extractDir, 0755)
+	archive := filepath.Join(extractDir, "test.tar.gz")
+	os.WriteFile(archive, buildTarGz(t, []archiveEntry{{name: "copy", hardlink: secret}}), 0644)
+
+	d := NewDownloader(DownloaderOptions{})
+	if _, err := d.ExtractArchive(base, "models", "test.tar.gz", "", nil); err == nil {
+		t.Fatal("hard link entry was extracted")
+	}
+	if _, err := os.Lstat(filepath.Join(extractDir, "copy")); !os.IsNotExist(err) {
+		t.Fatal("hard link was created")
+	}
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"file.bin", filepath.Join(dir, "file.bin"), false},
+		{"sub/file.bin", filepath.Join(dir, "sub", "file.bin"), false},
+		{`sub\file.bin`, filepath.Join(dir, "sub", "file.bin"), false},
+		{"sub/../file.bin", filepath.Join(dir, "file.bin"), false},
+		{"../file.bin", "", true},
+		{`..\file.bin`, "", true},
+		{"/etc/passwd", "", true},
+		{`\etc\passwd`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := archiveEntryPath(dir, tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("archiveEntryPath(%q) = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
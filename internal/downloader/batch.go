@@ -0,0 +1,296 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// defaultBatchWorkers is how many downloads of one batch run at once when
+// MaxConcurrent doesn't set a limit
+const defaultBatchWorkers = 8
+
+// BatchSummary describes a finished DownloadBatch call. It is sent to batch
+// subscribers as a "batch_complete" event.
+type BatchSummary struct {
+	Type      string  `json:"type"` // Always "batch_complete"
+	BatchID   string  `json:"batchId"`
+	Config    string  `json:"config,omitempty"`
+	Files     int     `json:"files"`
+	Completed int     `json:"completed"`
+	Failed    int     `json:"failed"`
+	Cancelled int     `json:"cancelled"`
+	Paused    int     `json:"paused"`
+	Skipped   int     `json:"skipped"` // Already on disk, not downloaded
+	Bytes     int64   `json:"bytes"`
+	Elapsed   float64 `json:"elapsedSeconds"`
+}
+
+// ErrBatchRunning is returned by StartBatch and Schedule for a batch ID
+// that is already running or scheduled
+var ErrBatchRunning = errors.New("batch is already running")
+
+// batchTracker collects the results of the downloads in one batch
+type batchTracker struct {
+	mu       sync.Mutex
+	summary  BatchSummary
+	recorded map[string]bool // File IDs whose result was added
+}
+
+func (b *batchTracker) add(fileID, status string, downloaded int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recorded[fileID] = true
+	switch status {
+	case "completed":
+		b.summary.Completed++
+	case "cancelled":
+		b.summary.Cancelled++
+	case "paused":
+		b.summary.Paused++
+	case "unchanged":
+		// Counted as skipped, nothing was downloaded
+	default:
+		b.summary.Failed++
+	}
+	b.summary.Bytes += downloaded
+}
+
+// DownloadBatch downloads entries concurrently and returns once all of them
+// have finished. batchID identifies the batch in its summary. The summary is broadcast to batch subscribers and, for
+// batches of more than one file, sent to the webhook in place of the
+// per-file notifications.
+//
+// A fixed pool of workers, MaxConcurrent or defaultBatchWorkers, takes the
+// entries highest priority first, so a large config never has more than
+// that many goroutines and open files. Entries waiting for a worker report
+// status "queued" and can be cancelled before they start.
+func (d *Downloader) DownloadBatch(ctx context.Context, batchID string, entries []config.FileEntry, rootDir string, opts DownloadOptions) BatchSummary {
+	batch := &batchTracker{
+		summary: BatchSummary{
+			Type:    "batch_complete",
+			BatchID: batchID,
+			Config:  opts.ConfigName,
+			Files:   len(entries),
+		},
+		recorded: make(map[string]bool),
+	}
+	webhookURL := opts.WebhookURL
+	if webhookURL == "" {
+		webhookURL = d.webhook
+	}
+
+	opts.batch = batch
+	if len(entries) > 1 {
+		opts.skipWebhook = true
+	}
+
+	start := time.Now()
+	sorted := byPriority(entries)
+	d.addUnstarted(sorted)
+
+	workers := defaultBatchWorkers
+	if d.maxConcurrent > 0 {
+		workers = d.maxConcurrent
+	}
+	jobs := make(chan config.FileEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(sorted)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				d.downloadBatchEntry(ctx, batch, entry, rootDir, opts)
+			}
+		}()
+	}
+	for _, entry := range sorted {
+		jobs <- entry
+	}
+	close(jobs)
+	wg.Wait()
+
+	batch.mu.Lock()
+	summary := batch.summary
+	batch.mu.Unlock()
+	summary.Skipped = summary.Files - summary.Completed - summary.Failed - summary.Cancelled - summary.Paused
+	summary.Elapsed = time.Since(start).Seconds()
+
+	d.logger.Info("batch finished", "batchId", summary.BatchID, "files", summary.Files,
+		"completed", summary.Completed, "failed", summary.Failed, "cancelled", summary.Cancelled, "paused", summary.Paused)
+	d.broadcastBatch(summary)
+	if webhookURL != "" && opts.skipWebhook {
+		d.notifyBatchWebhook(webhookURL, summary)
+	}
+	return summary
+}
+
+// StartBatch runs DownloadBatch in the background. A batchID that is still
+// running or scheduled is refused with ErrBatchRunning, so a retried
+// request doesn't start the same files twice.
+func (d *Downloader) StartBatch(batchID string, entries []config.FileEntry, rootDir string, opts DownloadOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closing {
+		return ErrShuttingDown
+	}
+	if d.batchActive(batchID) {
+		return fmt.Errorf("%w: %s", ErrBatchRunning, batchID)
+	}
+	d.runningBatches[batchID] = true
+	go d.runBatch(batchID, entries, rootDir, opts)
+	return nil
+}
+
+// batchActive reports whether batchID is running or scheduled. Must be
+// called with d.mu held.
+func (d *Downloader) batchActive(batchID string) bool {
+	_, scheduled := d.scheduled[batchID]
+	return scheduled || d.runningBatches[batchID]
+}
+
+// runBatch runs a batch marked in runningBatches and releases its ID once
+// it's done
+func (d *Downloader) runBatch(batchID string, entries []config.FileEntry, rootDir string, opts DownloadOptions) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.runningBatches, batchID)
+		d.mu.Unlock()
+	}()
+	d.DownloadBatch(context.Background(), batchID, entries, rootDir, opts)
+}
+
+// downloadBatchEntry downloads one entry of a batch, unless it was
+// cancelled while waiting for a worker
+func (d *Downloader) downloadBatchEntry(ctx context.Context, batch *batchTracker, entry config.FileEntry, rootDir string, opts DownloadOptions) {
+	if !d.takeUnstarted(entry.ID) {
+		batch.add(entry.ID, "cancelled", 0)
+		return
+	}
+	err := d.Download(ctx, entry, rootDir, opts)
+	if errors.Is(err, ErrAlreadyRunning) {
+		return // Counted as skipped, the running download reports its own result
+	}
+
+	// Downloads rejected before they started never reach recordResult
+	batch.mu.Lock()
+	rejected := err != nil && !batch.recorded[entry.ID]
+	batch.mu.Unlock()
+	if rejected {
+		batch.add(entry.ID, "error", 0)
+	}
+}
+
+// addUnstarted registers batch entries waiting for a worker and sets
+// their status to "queued", leaving downloads already running alone
+func (d *Downloader) addUnstarted(entries []config.FileEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, entry := range entries {
+		d.unstarted[entry.ID]++
+		if _, running := d.cancelFns[entry.ID]; running {
+			continue
+		}
+		p := &Progress{
+			FileID:     entry.ID,
+			FileName:   entry.FileName,
+			ETASeconds: -1,
+			Status:     "queued",
+		}
+		d.progress[entry.ID] = p
+		d.trackFinished(p)
+		d.broadcast(*p)
+	}
+	d.dirty = true
+}
+
+// takeUnstarted hands a waiting entry to a worker. It returns false if the
+// entry was cancelled in the meantime.
+func (d *Downloader) takeUnstarted(fileID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.unstarted[fileID] == 0 {
+		return false
+	}
+	d.unstarted[fileID]--
+	if d.unstarted[fileID] == 0 {
+		delete(d.unstarted, fileID)
+	}
+	return true
+}
+
+// cancelUnstarted cancels every batch entry still waiting for a worker and
+// returns their file IDs. Must be called with d.mu held.
+func (d *Downloader) cancelUnstarted() []string {
+	var cancelled []string
+	for fileID := range d.unstarted {
+		delete(d.unstarted, fileID)
+		d.markCancelled(fileID)
+		cancelled = append(cancelled, fileID)
+	}
+	return cancelled
+}
+
+// byPriority returns a copy of entries with higher Priority first, keeping
+// the original order among equal priorities
+func byPriority(entries []config.FileEntry) []config.FileEntry {
+	sorted := append([]config.FileEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	return sorted
+}
+
+// SubscribeBatches returns a channel receiving a summary each time a
+// DownloadBatch call finishes
+func (d *Downloader) SubscribeBatches() chan BatchSummary {
+	d.listenerMu.Lock()
+	defer d.listenerMu.Unlock()
+	ch := make(chan BatchSummary, 10)
+	d.batchListeners = append(d.batchListeners, ch)
+	return ch
+}
+
+// UnsubscribeBatches stops and closes a channel from SubscribeBatches
+func (d *Downloader) UnsubscribeBatches(ch chan BatchSummary) {
+	d.listenerMu.Lock()
+	defer d.listenerMu.Unlock()
+	for i, l := range d.batchListeners {
+		if l == ch {
+			d.batchListeners = append(d.batchListeners[:i], d.batchListeners[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+}
+
+func (d *Downloader) broadcastBatch(s BatchSummary) {
+	d.listenerMu.Lock()
+	defer d.listenerMu.Unlock()
+	for _, ch := range d.batchListeners {
+		select {
+		case ch <- s:
+		default:
+			// Batches are rare, a full channel means the subscriber is gone
+		}
+	}
+}
+
+func (d *Downloader) notifyBatchWebhook(webhookURL string, s BatchSummary) {
+	name := "Batch"
+	if s.Config != "" {
+		name = s.Config
+	}
+	text := fmt.Sprintf("%s finished: %d completed, %d failed, %d cancelled, %d skipped (%s in %.0fs)",
+		name, s.Completed, s.Failed, s.Cancelled, s.Skipped, formatBytes(s.Bytes), s.Elapsed)
+	d.postWebhook(webhookURL, struct {
+		Text    string `json:"text"`
+		Content string `json:"content"`
+		BatchSummary
+	}{text, text, s}, "batchId", s.BatchID)
+}
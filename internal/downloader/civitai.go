@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// civitaiAPIBase is the Civitai REST API root
+var civitaiAPIBase = "https://civitai.com/api/v1"
+
+// CivitaiFile is a downloadable file of a Civitai model version
+type CivitaiFile struct {
+	VersionID   int64  `json:"versionId"`
+	VersionName string `json:"versionName"`
+	FileName    string `json:"fileName"`
+	DownloadURL string `json:"downloadUrl"`
+	Size        int64  `json:"size"`           // Bytes, rounded from the API's kilobytes
+	Type        string `json:"type,omitempty"` // e.g. "Model", "VAE", "Config"
+	Primary     bool   `json:"primary"`        // The version's main file
+	SHA256      string `json:"sha256,omitempty"`
+}
+
+// civitaiVersion is the part of the API's model version object we use
+type civitaiVersion struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Files []struct {
+		Name        string  `json:"name"`
+		SizeKB      float64 `json:"sizeKB"`
+		Type        string  `json:"type"`
+		Primary     bool    `json:"primary"`
+		DownloadURL string  `json:"downloadUrl"`
+		Hashes      struct {
+			SHA256 string `json:"SHA256"`
+		} `json:"hashes"`
+	} `json:"files"`
+}
+
+// ParseCivitaiModelURL recognizes civitai.com model pages such as
+// /models/1234/name?modelVersionId=5678. versionID is 0 when the URL doesn't
+// pick a version. Direct download links are not model pages.
+func ParseCivitaiModelURL(rawURL string) (modelID, versionID int64, ok bool) {
+	if !IsCivitaiURL(rawURL) {
+		return 0, 0, false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, 0, false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "models" {
+		return 0, 0, false
+	}
+	modelID, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if v := u.Query().Get("modelVersionId"); v != "" {
+		versionID, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return modelID, versionID, true
+}
+
+// ResolveCivitaiModelURL looks up the files behind a Civitai model page.
+// With a version in the URL only that version's files are returned,
+// otherwise the files of every version, newest first. The primary file of
+// the selected (or newest) version comes first.
+func (d *Downloader) ResolveCivitaiModelURL(ctx context.Context, rawURL string, opts FileInfoOptions) ([]CivitaiFile, error) {
+	modelID, versionID, ok := ParseCivitaiModelURL(rawURL)
+	if !ok {
+		return nil, fmt.Errorf("not a Civitai model page: %s", redactURL(rawURL))
+	}
+
+	client, err := d.infoClient(opts.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	token := opts.Token
+	if opts.EntryToken != "" {
+		token = opts.EntryToken
+	}
+
+	var versions []civitaiVersion
+	if versionID != 0 {
+		var v civitaiVersion
+		if err := d.civitaiGet(ctx, client, fmt.Sprintf("/model-versions/%d", versionID), token, &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	} else {
+		var model struct {
+			ModelVersions []civitaiVersion `json:"modelVersions"`
+		}
+		if err := d.civitaiGet(ctx, client, fmt.Sprintf("/models/%d", modelID), token, &model); err != nil {
+			return nil, err
+		}
+		versions = model.ModelVersions
+	}
+
+	var files []CivitaiFile
+	for _, v := range versions {
+		start := len(files)
+		for _, f := range v.Files {
+			file := CivitaiFile{
+				VersionID:   v.ID,
+				VersionName: v.Name,
+				FileName:    f.Name,
+				DownloadURL: f.DownloadURL,
+				Size:        int64(f.SizeKB*1024 + 0.5),
+				Type:        f.Type,
+				Primary:     f.Primary,
+				SHA256:      strings.ToLower(f.Hashes.SHA256),
+			}
+			// Keep each version's primary file at the front of its group
+			if file.Primary {
+				files = append(files[:start], append([]CivitaiFile{file}, files[start:]...)...)
+			} else {
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("civitai model %d has no downloadable files", modelID)
+	}
+	return files, nil
+}
+
+// civitaiStatusError turns a failed Civitai download response into an
+// error saying what to do about it. Downloads redirect to signed storage
+// URLs, so an auth failure after the redirect means the link expired
+// rather than that the token is wrong.
+func civitaiStatusError(resp *http.Response) error {
+	redirected := !IsCivitaiURL(resp.Request.URL.String())
+	var msg string
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable || resp.StatusCode == http.StatusGone,
+		redirected && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden):
+		msg = "download link expired, refresh it from the model page"
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		msg = "invalid or missing Civitai token"
+	case resp.StatusCode == http.StatusNotFound:
+		msg = "model or file not found, it may have been removed"
+	default:
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return fmt.Errorf("%s (%s)", msg, resp.Status)
+}
+
+// civitaiGet fetches an API path and decodes the JSON response into v
+func (d *Downloader) civitaiGet(ctx context.Context, client *http.Client, path, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, civitaiAPIBase+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("civitai API: bad status: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("civitai API: invalid response: %w", err)
+	}
+	return nil
+}
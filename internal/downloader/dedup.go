@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// hashEntry is a downloaded file recorded in the hash index. Size and
+// modification time detect files changed since they were indexed.
+type hashEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// hashIndex maps SHA256 checksums to files downloaded with dedup enabled
+type hashIndex struct {
+	mu     sync.Mutex
+	path   string // Empty keeps the index in memory only
+	loaded bool
+	files  map[string][]hashEntry
+}
+
+func (x *hashIndex) load() {
+	if x.loaded {
+		return
+	}
+	x.loaded = true
+	x.files = make(map[string][]hashEntry)
+	if x.path == "" {
+		return
+	}
+	data, err := os.ReadFile(x.path)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &x.files)
+}
+
+func (x *hashIndex) save() error {
+	if x.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(x.files, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal hash index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(x.path), 0755); err != nil {
+		return fmt.Errorf("failed to create hash index directory: %w", err)
+	}
+	return config.WriteFileAtomic(x.path, data, 0644)
+}
+
+// dedupe replaces the file at path with a link to an identical file already
+// under rootDir, or records it in the index as the copy to link to next time.
+// Failures leave the downloaded file in place.
+func (d *Downloader) dedupe(logger *slog.Logger, rootDir, path, sum string) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	x := d.hashes
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.load()
+
+	root, err := config.ResolveRoot(rootDir)
+	if err != nil {
+		return
+	}
+
+	// Drop entries for files that are gone or were changed since
+	var kept []hashEntry
+	var target string
+	for _, e := range x.files[sum] {
+		current, err := os.Stat(e.Path)
+		if err != nil || current.Size() != e.Size || !current.ModTime().Equal(e.ModTime) {
+			continue
+		}
+		kept = append(kept, e)
+		if target == "" && e.Path != path && isUnder(root, e.Path) && !os.SameFile(current, info) {
+			target = e.Path
+		}
+	}
+
+	if target != "" {
+		if err := linkFile(target, path); err != nil {
+			logger.Warn("dedup link failed", "target", target, "error", err)
+		} else {
+			logger.Info("replaced duplicate file with a link", "path", path, "target", target)
+			if linked, err := os.Stat(path); err == nil {
+				info = linked
+			}
+		}
+	}
+
+	// Remember this copy too, so the index survives the original being removed
+	entry := hashEntry{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	for i, e := range kept {
+		if e.Path == path {
+			kept = append(kept[:i], kept[i+1:]...)
+			break
+		}
+	}
+	x.files[sum] = append(kept, entry)
+	if err := x.save(); err != nil {
+		logger.Warn("failed to save hash index", "path", x.path, "error", err)
+	}
+}
+
+// linkFile atomically replaces path with a hardlink to target, falling back
+// to a symlink where hardlinks aren't supported (e.g. across filesystems)
+func linkFile(target, path string) error {
+	tmp := path + ".link"
+	os.Remove(tmp)
+	if err := os.Link(target, tmp); err != nil {
+		if symErr := os.Symlink(target, tmp); symErr != nil {
+			return fmt.Errorf("hardlink: %v, symlink: %v", err, symErr)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// isUnder reports whether path is inside root
+func isUnder(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
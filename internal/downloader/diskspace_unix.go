@@ -0,0 +1,14 @@
+//go:build !windows
+
+package downloader
+
+import "syscall"
+
+// freeDiskSpace returns the bytes available to the current user on the filesystem containing path
+func freeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
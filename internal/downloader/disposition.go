@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"multy-loader/internal/config"
+)
+
+// parseContentDisposition extracts filename from Content-Disposition header.
+// The name is reduced to a plain file name, since servers may send paths.
+func parseContentDisposition(header string) string {
+	return config.CleanFileName(rawContentDisposition(header))
+}
+
+func rawContentDisposition(header string) string {
+	fields := scanDisposition(header)
+
+	// mime handles quoting and RFC 2231 continuations, but only decodes
+	// UTF-8 and ASCII extended values and otherwise returns the plain name
+	if _, params, err := mime.ParseMediaType(header); err == nil && params["filename"] != "" {
+		if fields.extended != "" && !mimeDecodes(fields.charset) {
+			return fields.extended
+		}
+		return params["filename"]
+	}
+
+	// Malformed headers, e.g. an unquoted name with spaces
+	if fields.extended != "" {
+		return fields.extended
+	}
+	return fields.plain
+}
+
+// dispositionFields are the filename parameters found by scanDisposition
+type dispositionFields struct {
+	plain    string // filename=
+	extended string // filename*= or filename*0*=..., decoded
+	charset  string // Declared charset of the extended value
+}
+
+// dispositionPiece is one part of an RFC 2231 continued parameter
+type dispositionPiece struct {
+	value   string
+	encoded bool // Percent-encoded (filename*N*=) rather than literal
+}
+
+// scanDisposition splits header on semicolons and picks out the filename
+// parameters without the strict syntax checks of mime.ParseMediaType
+func scanDisposition(header string) dispositionFields {
+	var fields dispositionFields
+	pieces := make(map[int]dispositionPiece)
+
+	for _, part := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch {
+		case key == "filename":
+			fields.plain = strings.Trim(value, "\"'")
+		case key == "filename*":
+			fields.extended, fields.charset = decodeExtValue(value)
+		case strings.HasPrefix(key, "filename*"):
+			// Continuations: filename*0="...", filename*1*=...
+			index := strings.TrimPrefix(key, "filename*")
+			encoded := strings.HasSuffix(index, "*")
+			n, err := strconv.Atoi(strings.TrimSuffix(index, "*"))
+			if err != nil || n < 0 {
+				continue
+			}
+			pieces[n] = dispositionPiece{value: strings.Trim(value, "\""), encoded: encoded}
+		}
+	}
+
+	if fields.extended == "" && len(pieces) > 0 {
+		fields.extended, fields.charset = joinPieces(pieces)
+	}
+	return fields
+}
+
+// decodeExtValue decodes an RFC 5987 value of the form charset'language'percent-encoded-name
+func decodeExtValue(value string) (name, charset string) {
+	value = strings.Trim(value, "\"")
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return value, ""
+	}
+	charset = parts[0]
+	raw, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return parts[2], charset
+	}
+	return decodeCharset(charset, []byte(raw)), charset
+}
+
+// joinPieces assembles continued parameters in order. Encoded pieces share
+// the charset declared at the start of the first one.
+func joinPieces(pieces map[int]dispositionPiece) (name, charset string) {
+	indexes := make([]int, 0, len(pieces))
+	for n := range pieces {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+
+	var buf []byte
+	for i, n := range indexes {
+		if n != i {
+			break // A gap ends the value, as RFC 2231 requires
+		}
+		p := pieces[n]
+		if !p.encoded {
+			buf = append(buf, p.value...)
+			continue
+		}
+		value := p.value
+		if n == 0 {
+			parts := strings.SplitN(value, "'", 3)
+			if len(parts) == 3 {
+				charset, value = parts[0], parts[2]
+			}
+		}
+		raw, err := url.PathUnescape(value)
+		if err != nil {
+			raw = value
+		}
+		buf = append(buf, raw...)
+	}
+	return decodeCharset(charset, buf), charset
+}
+
+// decodeCharset converts b from the declared charset to UTF-8. Single-byte
+// Western charsets are mapped byte for byte; others are kept as they are.
+func decodeCharset(charset string, b []byte) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	default:
+		return string(b)
+	}
+}
+
+// mimeDecodes reports whether mime.ParseMediaType decodes extended values
+// in charset
+func mimeDecodes(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "us-ascii":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"multy-loader/internal/config"
+)
+
+func TestParseContentDispositionHostile(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="..\\..\\windows\\system32\\evil.dll"`, "evil.dll"},
+		{`attachment; filename=..\..\evil.dll`, "evil.dll"},
+		{`attachment; filename="/abs/path/model.safetensors"`, "model.safetensors"},
+		{`attachment; filename="C:\Windows\evil.exe"`, "evil.exe"},
+		{`attachment; filename=".."`, ""},
+		{`attachment; filename="."`, ""},
+		{`attachment; filename="   "`, ""},
+		{`attachment; filename*=UTF-8''..%2F..%2Fevil.bin`, "evil.bin"},
+		{`attachment; filename*=UTF-8''%2E%2E`, ""},
+		{`attachment; filename*0="../../"; filename*1="evil.bin"`, "evil.bin"},
+		{`attachment; filename="safe.bin"; filename*=UTF-8''..%2F..%2F.ssh%2Fauthorized_keys`, "authorized_keys"},
+		{"attachment; filename=\"evil\tname.bin\"", "evil_name.bin"},
+		{"attachment; filename*=UTF-8''evil%00name.bin", "evil_name.bin"},
+		{`attachment; filename="mod:el?.bin"`, "mod_el_.bin"},
+		{`attachment; filename="a<b>|c.bin"`, "a_b__c.bin"},
+		{`attachment`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := parseContentDisposition(tt.header); got != tt.want {
+			t.Errorf("parseContentDisposition(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAutoNameStaysInFolder(t *testing.T) {
+	setAllowInternal(t, true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../../../evil.bin"`)
+		w.Header().Set("Content-Length", "5")
+		if r.Method == http.MethodGet {
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer srv.Close()
+
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDownloader(DownloaderOptions{})
+	entry := config.FileEntry{ID: "a", URL: srv.URL + "/download", Folder: "models"}
+	if err := d.Download(context.Background(), entry, root, DownloadOptions{AutoName: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "models", "evil.bin")); err != nil {
+		t.Errorf("file not saved in its folder: %v", err)
+	}
+	for _, escaped := range []string{filepath.Join(base, "evil.bin"), filepath.Join(root, "evil.bin")} {
+		if _, err := os.Stat(escaped); err == nil {
+			t.Errorf("file written outside its folder: %s", escaped)
+		}
+	}
+}
+
+func TestParseContentDisposition(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"civitai", `attachment; filename="ponyDiffusionV6XL_v6StartWithThisOne.safetensors"`, "ponyDiffusionV6XL_v6StartWithThisOne.safetensors"},
+		{"civitai extended", `attachment; filename="Juggernaut_X_RunDiffusion_Hyper.safetensors"; filename*=UTF-8''Juggernaut_X_RunDiffusion_Hyper.safetensors`, "Juggernaut_X_RunDiffusion_Hyper.safetensors"},
+		{"civitai apostrophe", `attachment; filename="Lykon's model.safetensors"`, "Lykon's model.safetensors"},
+		{"huggingface", `inline; filename*=UTF-8''diffusion_pytorch_model.fp16.safetensors; filename="diffusion_pytorch_model.fp16.safetensors";`, "diffusion_pytorch_model.fp16.safetensors"},
+		{"huggingface non-ascii", `attachment; filename*=UTF-8''%E6%A8%A1%E5%9E%8B.safetensors; filename="??.safetensors";`, "模型.safetensors"},
+		{"lowercase charset with language", `attachment; filename*=utf-8'en'%C3%A9t%C3%A9.ckpt`, "été.ckpt"},
+		{"quoted extended value", `attachment; filename*="UTF-8''quoted%20ext.bin"`, "quoted ext.bin"},
+		{"latin-1", `attachment; filename*=iso-8859-1'en'Mod%E8le.safetensors`, "Modèle.safetensors"},
+		{"encoded continuations", `attachment; filename*0*=UTF-8''long%20model%20; filename*1*=name%E2%80%93v2; filename*2=".safetensors"`, "long model name–v2.safetensors"},
+		{"plain continuations", `attachment; filename*0="part1_"; filename*1="part2.bin"`, "part1_part2.bin"},
+		{"windows-1252 continuations", `attachment; filename*0*=windows-1252''Caf%E9; filename*1*=.bin`, "Café.bin"},
+		{"continuation gap", `attachment; filename*0="a"; filename*2="c"`, "a"},
+		{"unquoted with spaces", `attachment; filename=my model v2.safetensors`, "my model v2.safetensors"},
+		{"upper case", `ATTACHMENT; FILENAME="Upper.bin"`, "Upper.bin"},
+	}
+	for _, tt := range tests {
+		if got := parseContentDisposition(tt.header); got != tt.want {
+			t.Errorf("%s: parseContentDisposition(%q) = %q, want %q", tt.name, tt.header, got, tt.want)
+		}
+	}
+}
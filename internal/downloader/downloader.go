@@ -1,16 +1,20 @@
 package downloader
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,6 +23,16 @@ import (
 	"multy-loader/internal/config"
 )
 
+// BufferSize is the read buffer size used when streaming downloads
+const BufferSize = 32 * 1024 // 32KB
+
+// defaultUserAgent is sent with every request unless DownloaderOptions sets
+// another one or an entry overrides it in its custom headers
+const defaultUserAgent = "multy-loader"
+
+// diskSpaceMargin is kept free on top of the file size when checking disk space
+const diskSpaceMargin = 64 * 1024 * 1024 // 64MB
+
 // Progress represents download progress
 type Progress struct {
 	FileID     string  `json:"fileId"`
@@ -26,45 +40,238 @@ type Progress struct {
 	Total      int64   `json:"total"`
 	Downloaded int64   `json:"downloaded"`
 	Percent    float64 `json:"percent"`
-	Speed      float64 `json:"speed"`  // bytes per second
-	Status     string  `json:"status"` // "downloading", "completed", "error", "cancelled"
+	Speed      float64 `json:"speed"`      // bytes per second, averaged over the last few seconds
+	ETASeconds int64   `json:"etaSeconds"` // Estimated time remaining, -1 if unknown
+	Status     string  `json:"status"`     // "scheduled", "queued", "downloading", "extracting", "completed", "unchanged", "error", "cancelled", "paused", "interrupted"
 	Error      string  `json:"error,omitempty"`
+	FinalURL   string  `json:"finalUrl,omitempty"` // Where redirects led, with credentials redacted
+
+	QueuePosition int `json:"queuePosition,omitempty"` // 1-based place in the queue while Status is "queued"
+
+	StartedAt      time.Time `json:"startedAt"`      // When Download picked the file up, including any wait for a slot; zero before that
+	ElapsedSeconds float64   `json:"elapsedSeconds"` // Time since StartedAt, frozen once the download finishes
 }
 
 // FileStatus represents the status of a file on disk
 type FileStatus struct {
-	Exists bool  `json:"exists"`
-	Size   int64 `json:"size"`
+	Exists           bool  `json:"exists"`
+	Size             int64 `json:"size"`
+	SizeMismatch     bool  `json:"sizeMismatch"`     // Exists, but not with the entry's expected size
+	ChecksumMismatch bool  `json:"checksumMismatch"` // Its sidecar records a SHA256 other than the entry's
+	Complete         bool  `json:"complete"`         // Exists with the expected size, and a sidecar confirms the expected checksum if there is one
+
+	// A .tmp file next to it, either being written by a running download
+	// (InProgress) or left behind by one that was paused or interrupted
+	// (Partial)
+	InProgress  bool  `json:"inProgress"`
+	Partial     bool  `json:"partial"`
+	PartialSize int64 `json:"partialSize"`
+}
+
+// maxListenerDrops is how many events in a row a subscriber may miss
+// before it's considered stuck and unsubscribed
+const maxListenerDrops = 50
+
+// listener is a progress subscriber
+type listener struct {
+	ch    chan Progress
+	drops int // Consecutive events dropped because ch was full
 }
 
 // Downloader handles file downloads
 type Downloader struct {
 	client     *http.Client
+	webhook    string       // Default webhook for downloads that don't set their own
+	hookClient *http.Client // Used for webhook deliveries
 	progress   map[string]*Progress
 	cancelFns  map[string]context.CancelFunc
+	tempFiles  map[string]string // Temp file path -> file ID of the download writing it, or kept for a paused download
+	batch      map[string]bool   // IDs of downloads started since the downloader was last idle
+	running    sync.WaitGroup    // In-flight Download calls
+	closing    bool              // Shutdown started, no new downloads are accepted
 	mu         sync.RWMutex
-	listeners  []chan Progress
-	listenerMu sync.RWMutex
+	listeners  []*listener
+	listenerMu sync.Mutex
+	statePath  string
+	dirty      bool // Progress changed since the last state save
+
+	timeouts    transportTimeouts
+	pool        transportPool
+	trust       transportTrust
+	idleTimeout time.Duration
+	logger      *slog.Logger
+	metrics     *metrics
+	hashes      *hashIndex
+
+	batchListeners []chan BatchSummary // Guarded by listenerMu
+
+	unstarted      map[string]int  // Batch entries waiting for a worker, by file ID
+	runningBatches map[string]bool // IDs of batches started by StartBatch or the scheduler that haven't finished
+
+	pausing map[string]bool            // Running downloads Pause was called for
+	paused  map[string]*pausedDownload // Paused downloads waiting for Resume
+
+	progressTTL time.Duration        // Finished downloads are dropped from progress after this long, <= 0 keeps them
+	finishedAt  map[string]time.Time // When each finished download in progress reached its final status
+
+	history *history // Nil when history is disabled
+
+	scheduled      map[string]*scheduledBatch // Batches waiting for their start time, by batch ID
+	scheduleSaveMu sync.Mutex                 // Keeps schedule file writes in order
+
+	startedAt time.Time
+	peakSpeed float64 // Highest combined speed of active downloads, guarded by mu
+
+	maxRedirects int    // Redirects a download may follow
+	userAgent    string // Sent with download and metadata requests
+
+	maxConcurrent int               // Downloads transferring at once, <= 0 is unlimited
+	active        int               // Downloads holding a slot, guarded by mu
+	queue         []*queuedDownload // Downloads waiting for a slot, oldest first, guarded by mu
+}
+
+// DownloaderOptions configures a Downloader. Zero timeouts use the defaults.
+type DownloaderOptions struct {
+	StatePath  string       // JSON file used to persist progress across restarts, empty disables it
+	Logger     *slog.Logger // Defaults to slog.Default()
+	WebhookURL string       // Notified when downloads complete or fail, unless DownloadOptions sets its own
+	HashIndex  string       // JSON file recording checksums of deduplicated downloads, empty keeps it in memory
+	HistoryLog string       // JSON lines file every finished download is appended to, empty disables history
+
+	DialTimeout           time.Duration // Time allowed to establish a TCP connection
+	TLSHandshakeTimeout   time.Duration // Time allowed for the TLS handshake
+	ResponseHeaderTimeout time.Duration // Time allowed between sending a request and receiving response headers
+	IdleTimeout           time.Duration // Abort a download when no bytes arrive for this long, negative disables it
+	MaxRedirects          int           // Redirects a download may follow, zero uses 10
+	UserAgent             string        // Sent with every request unless an entry's headers override it, empty uses "multy-loader"
+
+	// Connection pooling, shared by all downloads through the same proxy.
+	// Zero uses the defaults: 16 idle connections kept per host for 90
+	// seconds, and no limit on connections per host.
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+
+	// RootCAs are the certificate authorities trusted for HTTPS, nil uses
+	// the system roots. See LoadCABundle. InsecureSkipVerify accepts any
+	// certificate and is only meant for internal servers with self-signed
+	// ones.
+	RootCAs            *x509.CertPool
+	InsecureSkipVerify bool
+
+	// ProgressTTL is how long completed, failed and cancelled downloads stay
+	// in the progress map. Zero uses 10 minutes, negative keeps them forever.
+	ProgressTTL time.Duration
+
+	// MaxConcurrent limits how many downloads transfer at once, the rest
+	// wait in a queue. Zero means no limit.
+	MaxConcurrent int
 }
 
 // NewDownloader creates a new downloader
-func NewDownloader() *Downloader {
-	return &Downloader{
+func NewDownloader(opts DownloaderOptions) *Downloader {
+	timeouts := defaultTransportTimeouts()
+	if opts.DialTimeout > 0 {
+		timeouts.dial = opts.DialTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		timeouts.tlsHandshake = opts.TLSHandshakeTimeout
+	}
+	if opts.ResponseHeaderTimeout > 0 {
+		timeouts.responseHeader = opts.ResponseHeaderTimeout
+	}
+	pool := defaultTransportPool()
+	if opts.MaxIdleConnsPerHost > 0 {
+		pool.maxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.MaxConnsPerHost > 0 {
+		pool.maxConnsPerHost = opts.MaxConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		pool.idleConnTimeout = opts.IdleConnTimeout
+	}
+	trust := transportTrust{rootCAs: opts.RootCAs, insecure: opts.InsecureSkipVerify}
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	progressTTL := opts.ProgressTTL
+	if progressTTL == 0 {
+		progressTTL = defaultProgressTTL
+	}
+
+	// Only an explicit proxy URL can be invalid
+	transport, _ := proxyTransport("", timeouts, pool, trust)
+
+	d := &Downloader{
 		client: &http.Client{
-			Timeout: 0, // No timeout for large files
+			Transport: transport,
+			Timeout:   0, // No overall timeout for large files, stalls are caught by idleTimeout
 		},
-		progress:  make(map[string]*Progress),
-		cancelFns: make(map[string]context.CancelFunc),
-		listeners: make([]chan Progress, 0),
+		webhook:        opts.WebhookURL,
+		hookClient:     &http.Client{Timeout: webhookTimeout},
+		progress:       make(map[string]*Progress),
+		cancelFns:      make(map[string]context.CancelFunc),
+		tempFiles:      make(map[string]string),
+		batch:          make(map[string]bool),
+		listeners:      make([]*listener, 0),
+		statePath:      opts.StatePath,
+		timeouts:       timeouts,
+		pool:           pool,
+		trust:          trust,
+		idleTimeout:    idleTimeout,
+		logger:         opts.Logger,
+		metrics:        newMetrics(),
+		hashes:         &hashIndex{path: opts.HashIndex},
+		unstarted:      make(map[string]int),
+		runningBatches: make(map[string]bool),
+		pausing:        make(map[string]bool),
+		paused:         make(map[string]*pausedDownload),
+		progressTTL:    progressTTL,
+		finishedAt:     make(map[string]time.Time),
+		scheduled:      make(map[string]*scheduledBatch),
+		startedAt:      time.Now(),
+	}
+	if d.logger == nil {
+		d.logger = slog.Default()
+	}
+	d.maxRedirects = opts.MaxRedirects
+	if d.maxRedirects <= 0 {
+		d.maxRedirects = defaultMaxRedirects
 	}
+	d.client.CheckRedirect = redirectPolicy(d.maxRedirects, d.logger)
+	d.userAgent = opts.UserAgent
+	if d.userAgent == "" {
+		d.userAgent = defaultUserAgent
+	}
+	d.maxConcurrent = opts.MaxConcurrent
+	if opts.HistoryLog != "" {
+		d.history = newHistory(opts.HistoryLog, d.logger)
+	}
+
+	if d.statePath != "" {
+		if err := d.loadState(); err != nil {
+			d.logger.Warn("failed to restore download progress", "path", d.statePath, "error", err)
+		}
+		if err := d.loadSchedules(); err != nil {
+			d.logger.Warn("failed to restore scheduled downloads", "path", d.schedulePath(), "error", err)
+		}
+		go d.persistLoop()
+	}
+	if d.progressTTL > 0 {
+		go d.reapLoop()
+	}
+	go d.scheduleLoop()
+	return d
 }
 
-// Subscribe to progress updates
+// Subscribe to progress updates. The channel is closed when the subscriber
+// stops draining it for too long, as well as by Unsubscribe.
 func (d *Downloader) Subscribe() chan Progress {
 	d.listenerMu.Lock()
 	defer d.listenerMu.Unlock()
 	ch := make(chan Progress, 100)
-	d.listeners = append(d.listeners, ch)
+	d.listeners = append(d.listeners, &listener{ch: ch})
 	return ch
 }
 
@@ -72,8 +279,8 @@ func (d *Downloader) Subscribe() chan Progress {
 func (d *Downloader) Unsubscribe(ch chan Progress) {
 	d.listenerMu.Lock()
 	defer d.listenerMu.Unlock()
-	for i, listener := range d.listeners {
-		if listener == ch {
+	for i, l := range d.listeners {
+		if l.ch == ch {
 			d.listeners = append(d.listeners[:i], d.listeners[i+1:]...)
 			close(ch)
 			break
@@ -82,56 +289,251 @@ func (d *Downloader) Unsubscribe(ch chan Progress) {
 }
 
 func (d *Downloader) broadcast(p Progress) {
-	d.listenerMu.RLock()
-	defer d.listenerMu.RUnlock()
-	for _, ch := range d.listeners {
+	d.listenerMu.Lock()
+	defer d.listenerMu.Unlock()
+
+	kept := d.listeners[:0]
+	for _, l := range d.listeners {
 		select {
-		case ch <- p:
+		case l.ch <- p:
+			l.drops = 0
 		default:
-			// Channel full, skip
+			// Channel full, skip. A listener that keeps missing events
+			// is stuck (e.g. a dead client) and gets dropped.
+			l.drops++
+			if l.drops >= maxListenerDrops {
+				close(l.ch)
+				continue
+			}
 		}
+		kept = append(kept, l)
+	}
+	// Clear the tail so dropped listeners can be collected
+	for i := len(kept); i < len(d.listeners); i++ {
+		d.listeners[i] = nil
 	}
+	d.listeners = kept
 }
 
-// GetProgress returns current progress for a file
-func (d *Downloader) GetProgress(fileID string) *Progress {
+// GetProgress returns a snapshot of a file's progress, false if it has none.
+// The progress map is updated in place, so callers only ever get copies.
+func (d *Downloader) GetProgress(fileID string) (Progress, bool) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 	if p, ok := d.progress[fileID]; ok {
-		return p
+		return d.snapshot(p), true
 	}
-	return nil
+	return Progress{}, false
 }
 
-// GetAllProgress returns all active downloads
-func (d *Downloader) GetAllProgress() map[string]*Progress {
+// GetAllProgress returns a snapshot of all tracked downloads
+func (d *Downloader) GetAllProgress() map[string]Progress {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	result := make(map[string]*Progress)
+	result := make(map[string]Progress, len(d.progress))
 	for k, v := range d.progress {
-		result[k] = v
+		result[k] = d.snapshot(v)
 	}
 	return result
 }
 
-// CheckFileStatus checks if a file exists and its size
-func (d *Downloader) CheckFileStatus(rootDir, folder, fileName string) FileStatus {
-	fullPath := filepath.Join(config.ExpandPath(rootDir), folder, fileName)
+// snapshot copies p with its elapsed time brought up to date. Must be
+// called with d.mu held.
+func (d *Downloader) snapshot(p *Progress) Progress {
+	s := *p
+	s.ElapsedSeconds = d.elapsed(p)
+	return s
+}
+
+// elapsed is how long p's download has been running. It stops moving once
+// the Download call returns. Must be called with d.mu held.
+func (d *Downloader) elapsed(p *Progress) float64 {
+	if _, running := d.cancelFns[p.FileID]; !running || p.StartedAt.IsZero() {
+		return p.ElapsedSeconds
+	}
+	return time.Since(p.StartedAt).Seconds()
+}
+
+// AggregateProgress summarizes the current batch of downloads, i.e. all
+// downloads started since the downloader was last idle
+type AggregateProgress struct {
+	Files      int     `json:"files"`
+	Active     int     `json:"active"`
+	Completed  int     `json:"completed"`
+	Failed     int     `json:"failed"` // Errored or cancelled
+	Total      int64   `json:"total"`  // Sum of known file sizes
+	Downloaded int64   `json:"downloaded"`
+	Percent    float64 `json:"percent"`
+	Speed      float64 `json:"speed"` // Combined bytes per second of active downloads
+}
+
+// GetAggregateProgress returns combined progress of the current batch
+func (d *Downloader) GetAggregateProgress() AggregateProgress {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var a AggregateProgress
+	for id := range d.batch {
+		p, ok := d.progress[id]
+		if !ok {
+			continue
+		}
+		a.Files++
+		if p.Total > 0 {
+			a.Total += p.Total
+		}
+		a.Downloaded += p.Downloaded
+		switch p.Status {
+		case "downloading":
+			a.Active++
+			a.Speed += p.Speed
+		case "completed", "unchanged":
+			a.Completed++
+		case "error", "cancelled":
+			a.Failed++
+		}
+	}
+	if a.Total > 0 {
+		a.Percent = float64(a.Downloaded) / float64(a.Total) * 100
+	}
+	return a
+}
+
+// CheckFileStatus checks if a file exists, its size, and whether a download
+// left a temp file next to it. An expectedSize above zero is compared with
+// the size on disk. A non-empty expectedSHA256 is compared with the
+// checksum in the file's sidecar, when it has one; files aren't hashed
+// here, so without a matching sidecar the file isn't Complete.
+func (d *Downloader) CheckFileStatus(rootDir, folder, fileName string, expectedSize int64, expectedSHA256 string) (FileStatus, error) {
+	fullPath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return FileStatus{}, err
+	}
+
+	var status FileStatus
+	tmpPath := fullPath + ".tmp"
+	if info, err := os.Stat(tmpPath); err == nil {
+		d.mu.RLock()
+		owner, ok := d.tempFiles[tmpPath]
+		_, running := d.cancelFns[owner]
+		d.mu.RUnlock()
+		status.InProgress = ok && running
+		status.Partial = !status.InProgress
+		status.PartialSize = info.Size()
+	}
+
 	info, err := os.Stat(fullPath)
 	if err != nil {
-		return FileStatus{Exists: false, Size: 0}
+		return status, nil
+	}
+	status.Exists = true
+	status.Size = info.Size()
+	status.SizeMismatch = expectedSize > 0 && info.Size() != expectedSize
+	verified := false
+	if expectedSHA256 != "" {
+		if meta, err := readFileMeta(fullPath); err == nil && meta.SHA256 != "" && meta.Size == info.Size() {
+			status.ChecksumMismatch = !strings.EqualFold(meta.SHA256, expectedSHA256)
+			verified = !status.ChecksumMismatch
+		}
 	}
-	return FileStatus{Exists: true, Size: info.Size()}
+	status.Complete = !status.SizeMismatch && (expectedSHA256 == "" || verified)
+	return status, nil
+}
+
+// DownloadOptions holds per-request download settings
+type DownloadOptions struct {
+	Token       string       // Auth token appended to URLs of entries with UseToken
+	HFToken     string       // HuggingFace token sent as a bearer header for entries with UseToken
+	Force       bool         // Re-download even if the file already exists
+	Limiter     *RateLimiter `json:"-"` // Optional bandwidth limiter, may be shared between downloads
+	Connections int          // Parallel range requests per file, <= 1 means a single stream
+	ProxyURL    string       // Explicit proxy, overrides HTTP_PROXY/HTTPS_PROXY when set
+
+	// StallTimeout fails the download when no bytes are written for this long,
+	// even if the connection stays open. Zero disables it.
+	StallTimeout time.Duration
+
+	WebhookURL string // Notified when the download completes or fails, overrides DownloaderOptions.WebhookURL
+	ConfigName string // Config the entry belongs to, included in webhook payloads
+
+	// AutoName names entries without a file name (or with just an ID)
+	// after the server's Content-Disposition. Explicit names are kept.
+	AutoName bool
+
+	// Dedup replaces the downloaded file with a hardlink to an identical
+	// file already under the root, found through the hash index
+	Dedup bool
+
+	// AllowHTML saves HTML responses even for model and archive files,
+	// which are otherwise rejected as login or error pages
+	AllowHTML bool
+
+	// VerifySize only skips an existing file when its size matches the
+	// server's, so truncated files are downloaded again. Costs a HEAD
+	// request per existing file.
+	VerifySize bool
+
+	// MinSize fails downloads smaller than this many bytes and removes them,
+	// catching expired links that answer 200 with an empty body. Empty
+	// downloads always fail unless the entry sets AllowEmpty.
+	MinSize int64
+
+	// Refresh downloads existing files again only if the server has a newer
+	// version. The ETag or Last-Modified of each refreshed file is kept in a
+	// .meta.json sidecar and sent as a conditional request next time; a 304
+	// leaves the file alone with status "unchanged". Files without a sidecar
+	// yet, and auto-named ones, are downloaded in full.
+	Refresh bool
+
+	// WriteMeta writes the .meta.json sidecar for every download, recording
+	// its source URL, config, time, size and checksum
+	WriteMeta bool
+
+	batch           *batchTracker // Set by DownloadBatch to collect results
+	skipWebhook     bool          // The batch summary is sent instead of per-file notifications
+	resumed         bool          // Restarted by Resume, already counted as started
+	continuePartial bool          // Continue the existing temp file with a Range request
 }
 
 // Download downloads a file
-func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootDir string, token string, force bool) error {
-	fullPath := filepath.Join(config.ExpandPath(rootDir), entry.Folder, entry.FileName)
+func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootDir string, opts DownloadOptions) (err error) {
+	logger := d.logger.With("fileId", entry.ID, "host", urlHost(entry.URL))
 
-	// Check if file exists and we're not forcing redownload
-	if !force {
-		if _, err := os.Stat(fullPath); err == nil {
-			return nil // File exists, skip
+	// Expand ${VAR} up front so progress and history carry the real names
+	if entry.Folder, err = config.ExpandVars(entry.Folder); err == nil {
+		entry.FileName, err = config.ExpandVars(entry.FileName)
+	}
+	if err != nil {
+		logger.Warn("download rejected", "error", err)
+		return err
+	}
+
+	// Without a real name, start from the URL's and take the server's
+	// suggestion once the response arrives
+	autoName := opts.AutoName && isPlaceholderName(entry.FileName)
+	if autoName && entry.FileName == "" {
+		entry.FileName = extractFileNameFromURL(entry.URL)
+		if entry.FileName == "" {
+			entry.FileName = "download"
+		}
+	}
+
+	fullPath, err := config.SafeJoin(rootDir, entry.Folder, entry.FileName)
+	if err != nil {
+		logger.Warn("download rejected", "error", err)
+		return err
+	}
+
+	// Check if file exists and we're not forcing redownload. Auto-named
+	// files are checked once their name is known.
+	if !opts.Force && !opts.Refresh && !autoName {
+		if info, err := os.Stat(fullPath); err == nil {
+			sizeOK := entry.Size <= 0 || info.Size() == entry.Size
+			if sizeOK && (!opts.VerifySize || d.remoteSizeMatches(entry, opts, info.Size(), logger)) {
+				logger.Debug("file exists, skipping download", "fileName", entry.FileName)
+				return nil // File exists, skip
+			}
+			logger.Info("existing file has the wrong size, downloading again", "fileName", entry.FileName, "size", info.Size())
 		}
 	}
 
@@ -141,31 +543,139 @@ func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootD
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Build download URL with token if needed
-	downloadURL := entry.URL
-	if entry.UseToken && token != "" {
-		downloadURL = appendToken(entry.URL, token)
-	}
+	downloadURL, headers := entryRequest(entry, opts.Token, opts.HFToken, d.userAgent)
 
 	// Create context with cancel
 	ctx, cancel := context.WithCancel(ctx)
+	tmpPath := fullPath + ".tmp"
 	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		cancel()
+		return ErrShuttingDown
+	}
+	if _, running := d.cancelFns[entry.ID]; running {
+		// A retried request must not start a second writer on the same temp file
+		d.mu.Unlock()
+		cancel()
+		logger.Info("download already running, not starting it again", "fileName", entry.FileName)
+		return fmt.Errorf("%w: %s", ErrAlreadyRunning, entry.ID)
+	}
+	if owner, ok := d.tempFiles[tmpPath]; ok && owner != entry.ID {
+		// Another entry resolves to the same file, both would write its temp file
+		err := fmt.Errorf("%w: %s (file %s)", ErrPathInUse, filepath.Join(entry.Folder, entry.FileName), owner)
+		p := &Progress{
+			FileID:     entry.ID,
+			FileName:   entry.FileName,
+			ETASeconds: -1,
+			Status:     "error",
+			Error:      err.Error(),
+		}
+		d.progress[entry.ID] = p
+		d.trackFinished(p)
+		d.dirty = true
+		d.broadcast(*p)
+		d.mu.Unlock()
+		cancel()
+		logger.Warn("download rejected", "error", err)
+		return err
+	}
+	d.running.Add(1)
+	defer d.running.Done()
+	if len(d.cancelFns) == 0 {
+		// Nothing running, so this download starts a new batch
+		d.batch = make(map[string]bool)
+	}
+	d.batch[entry.ID] = true
 	d.cancelFns[entry.ID] = cancel
+	d.tempFiles[tmpPath] = entry.ID
 	d.progress[entry.ID] = &Progress{
-		FileID:   entry.ID,
-		FileName: entry.FileName,
-		Status:   "downloading",
+		FileID:     entry.ID,
+		FileName:   entry.FileName,
+		ETASeconds: -1,
+		Status:     "downloading",
+		StartedAt:  time.Now(),
 	}
+	delete(d.finishedAt, entry.ID)
+	d.dirty = true
 	d.mu.Unlock()
+	if !opts.resumed {
+		d.metrics.started.Add(1)
+	}
+
+	// Stalls cancel with a cause so they can be told apart from user cancellation
+	ctx, cancelStall := context.WithCancelCause(ctx)
+	defer cancelStall(nil)
 
+	start := time.Now()
+	logger.Info("download started", "fileName", entry.FileName, "connections", opts.Connections)
+	defer func() {
+		d.recordResult(logger, entry, opts, time.Since(start), err)
+	}()
+
+	keepPartial := false
 	defer func() {
 		d.mu.Lock()
 		delete(d.cancelFns, entry.ID)
+		if d.pausing[entry.ID] && err != nil {
+			// Registered only now, so Resume can't start it again while
+			// this call is still cleaning up
+			d.paused[entry.ID] = &pausedDownload{
+				entry:   entry,
+				rootDir: rootDir,
+				opts:    opts,
+				tmpPath: tmpPath,
+				partial: keepPartial,
+			}
+			if p, ok := d.progress[entry.ID]; ok {
+				p.Status = "paused"
+				p.Error = ""
+				p.Speed = 0
+				p.ETASeconds = -1
+				d.trackFinished(p)
+				d.dirty = true
+				d.broadcast(*p)
+			}
+		} else {
+			delete(d.tempFiles, tmpPath)
+		}
+		delete(d.pausing, entry.ID)
 		d.mu.Unlock()
 	}()
 
+	// Wait for a free slot when MaxConcurrent downloads are already running
+	if err := d.acquireSlot(ctx, entry.ID); err != nil {
+		if d.isPausing(entry.ID) {
+			return ErrPaused
+		}
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "cancelled"
+			p.QueuePosition = 0
+		})
+		return err
+	}
+	defer d.releaseSlot()
+
+	client, err := d.clientFor(opts.ProxyURL)
+	if err != nil {
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
+	client = withCookieJar(client)
+
+	// A resumed download continues where its temp file ends
+	var offset int64
+	if opts.continuePartial {
+		if info, err := os.Stat(tmpPath); err == nil {
+			offset = info.Size()
+		}
+	}
+
 	// Start download
-	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	req, err := newDownloadRequest(ctx, downloadURL, headers)
 	if err != nil {
 		d.updateProgress(entry.ID, func(p *Progress) {
 			p.Status = "error"
@@ -173,19 +683,66 @@ func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootD
 		})
 		return err
 	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// A refresh only transfers the file if the server has a newer one
+	conditional := false
+	if opts.Refresh && offset == 0 && !autoName {
+		if _, err := os.Stat(fullPath); err == nil {
+			if meta, err := readFileMeta(fullPath); err == nil && meta.URL == entry.URL {
+				conditional = setConditional(req, meta)
+			}
+		}
+	}
 
-	resp, err := d.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
+		err = redactError(err)
 		d.updateProgress(entry.ID, func(p *Progress) {
 			p.Status = "error"
 			p.Error = err.Error()
 		})
 		return err
 	}
+	// Every reader below, from sniffing to decoding to copying, reads
+	// through this one idle watch
+	resp.Body = d.watchIdle(resp.Body)
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if final := resp.Request.URL.String(); final != req.URL.String() {
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.FinalURL = redactURL(final)
+		})
+	}
+
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		logger.Debug("file unchanged on the server", "fileName", entry.FileName)
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "unchanged"
+			p.Percent = 100
+			p.ETASeconds = 0
+		})
+		return nil
+	}
+
+	// Servers that ignore the Range header send the whole file again
+	total := resp.ContentLength
+	if offset > 0 {
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if resp.StatusCode == http.StatusPartialContent && ok && start == offset {
+			total = size
+		} else {
+			offset = 0
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK && offset == 0 {
 		err := fmt.Errorf("bad status: %s", resp.Status)
+		if IsCivitaiURL(entry.URL) {
+			err = civitaiStatusError(resp)
+		}
 		d.updateProgress(entry.ID, func(p *Progress) {
 			p.Status = "error"
 			p.Error = err.Error()
@@ -193,9 +750,94 @@ func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootD
 		return err
 	}
 
-	// Create temp file
-	tmpPath := fullPath + ".tmp"
-	file, err := os.Create(tmpPath)
+	if autoName {
+		if name := suggestedFileName(resp); name != "" && name != entry.FileName {
+			if newPath, err := config.SafeJoin(rootDir, entry.Folder, name); err == nil {
+				d.mu.Lock()
+				if owner, ok := d.tempFiles[newPath+".tmp"]; ok && owner != entry.ID {
+					d.mu.Unlock()
+					err := fmt.Errorf("%w: %s (file %s)", ErrPathInUse, filepath.Join(entry.Folder, name), owner)
+					d.updateProgress(entry.ID, func(p *Progress) {
+						p.Status = "error"
+						p.Error = err.Error()
+					})
+					return err
+				}
+				delete(d.tempFiles, tmpPath)
+				fullPath, tmpPath = newPath, newPath+".tmp"
+				d.tempFiles[tmpPath] = entry.ID
+				d.mu.Unlock()
+				entry.FileName = name
+				d.updateProgress(entry.ID, func(p *Progress) {
+					p.FileName = name
+				})
+				logger.Debug("using server file name", "fileName", name)
+			}
+		}
+		if !opts.Force && !opts.Refresh {
+			info, err := os.Stat(fullPath)
+			if err == nil && (entry.Size > 0 && info.Size() != entry.Size ||
+				opts.VerifySize && offset == 0 && resp.ContentLength >= 0 && info.Size() != resp.ContentLength) {
+				logger.Info("existing file has the wrong size, downloading again", "fileName", entry.FileName, "size", info.Size())
+			} else if err == nil {
+				logger.Debug("file exists, skipping download", "fileName", entry.FileName)
+				d.updateProgress(entry.ID, func(p *Progress) {
+					p.Status = "completed"
+					p.Percent = 100
+					p.ETASeconds = 0
+				})
+				return nil
+			}
+		}
+	}
+
+	// Save what the server encoded, not the encoding. The decoded size isn't
+	// known up front, and a range of the encoded stream can't be appended
+	// to decoded bytes.
+	encoding, err := contentEncoding(resp, entry.FileName)
+	if err == nil && encoding != "" {
+		if offset > 0 {
+			os.Remove(tmpPath)
+			err = fmt.Errorf("can't resume a %s-encoded transfer, download it again", encoding)
+		} else {
+			resp.Body, err = decodeBody(resp.Body, encoding)
+			total = -1
+		}
+	}
+	if err != nil {
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
+
+	// Auth walls often answer with a 200 login page, don't save it as a model
+	if !opts.AllowHTML && offset == 0 && expectsBinary(entry.FileName) && isHTMLResponse(resp) {
+		err := fmt.Errorf("server sent an HTML page instead of %s, likely a login or error page", entry.FileName)
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
+
+	// Make sure the file fits before writing anything
+	if err := checkDiskSpace(dir, resp.ContentLength); err != nil {
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
+
+	// Create temp file, or append to the one being resumed
+	var file *os.File
+	if offset > 0 {
+		file, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_APPEND, 0)
+	} else {
+		file, err = os.Create(tmpPath)
+	}
 	if err != nil {
 		d.updateProgress(entry.ID, func(p *Progress) {
 			p.Status = "error"
@@ -204,80 +846,122 @@ func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootD
 		return err
 	}
 
-	total := resp.ContentLength
 	d.updateProgress(entry.ID, func(p *Progress) {
 		p.Total = total
 	})
 
-	// Download with progress tracking
-	startTime := time.Now()
-	var downloaded int64
-	buf := make([]byte, 32*1024) // 32KB buffer
+	tracker := newProgressTracker(total, func(fn func(p *Progress)) {
+		d.updateProgress(entry.ID, fn)
+	})
+	tracker.startAt(offset)
+	tracker.received = &d.metrics.bytes
+	if opts.StallTimeout > 0 {
+		go watchStall(ctx, tracker, opts.StallTimeout, cancelStall)
+	}
 
-	// Throttle progress updates (update max once per 200ms or 1% change)
-	lastUpdate := time.Now()
-	lastPercent := float64(0)
-	updateInterval := 200 * time.Millisecond
+	hasher := sha256.New()
+	segmented := false
 
-	for {
-		select {
-		case <-ctx.Done():
-			file.Close()
-			os.Remove(tmpPath)
+	var downloaded int64
+	if conns := segmentCount(opts.Connections, resp, total); conns > 1 && offset == 0 && !isEncoded(resp) {
+		// The initial response is only used to probe range support
+		resp.Body.Close()
+		segmented = true
+		downloaded, err = d.downloadSegments(ctx, client, downloadURL, headers, file, total, conns, opts, tracker)
+	} else {
+		var body io.Reader = resp.Body
+		if opts.Limiter != nil {
+			body = &rateLimitedReader{ctx: ctx, r: body, limiter: opts.Limiter}
+		}
+		// Hash the data while writing it to the temp file
+		downloaded, err = copyWithProgress(ctx, io.MultiWriter(file, hasher), body, tracker)
+		downloaded += offset
+	}
+	file.Close()
+
+	if err != nil {
+		err = redactError(err)
+		if ctx.Err() != nil && !segmented && d.isPausing(entry.ID) {
+			keepPartial = true
+			return ErrPaused
+		}
+		os.Remove(tmpPath)
+		if cause := context.Cause(ctx); errors.Is(cause, errStalled) {
+			d.updateProgress(entry.ID, func(p *Progress) {
+				p.Status = "error"
+				p.Error = cause.Error()
+			})
+			return cause
+		}
+		if ctx.Err() != nil {
 			d.updateProgress(entry.ID, func(p *Progress) {
 				p.Status = "cancelled"
 			})
 			return ctx.Err()
-		default:
 		}
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
 
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			_, writeErr := file.Write(buf[:n])
-			if writeErr != nil {
-				file.Close()
-				os.Remove(tmpPath)
-				d.updateProgress(entry.ID, func(p *Progress) {
-					p.Status = "error"
-					p.Error = writeErr.Error()
-				})
-				return writeErr
-			}
-			downloaded += int64(n)
+	// A connection closed early can look like a clean EOF, so don't trust
+	// the copy alone when the server told us the size
+	if total > 0 && downloaded != total {
+		os.Remove(tmpPath)
+		err := fmt.Errorf("incomplete: got %d of %d bytes", downloaded, total)
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
 
-			// Calculate progress
-			elapsed := time.Since(startTime).Seconds()
-			var percent float64
-			if total > 0 {
-				percent = float64(downloaded) / float64(total) * 100
-			}
-			var speed float64
-			if elapsed > 0 {
-				speed = float64(downloaded) / elapsed
-			}
+	if entry.Size > 0 && downloaded != entry.Size {
+		os.Remove(tmpPath)
+		err := fmt.Errorf("size mismatch: expected %d bytes, got %d", entry.Size, downloaded)
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
 
-			// Throttle updates: only update if enough time passed or significant change
-			now := time.Now()
-			percentChanged := percent - lastPercent
-			shouldUpdate := now.Sub(lastUpdate) >= updateInterval || percentChanged >= 1.0 || percentChanged <= -1.0
+	// An expired link can still answer 200, just with nothing in it
+	if minSize := max(opts.MinSize, 1); !entry.AllowEmpty && downloaded < minSize {
+		os.Remove(tmpPath)
+		err := fmt.Errorf("server sent %d bytes, expected at least %d; the link may have expired", downloaded, minSize)
+		d.updateProgress(entry.ID, func(p *Progress) {
+			p.Status = "error"
+			p.Error = err.Error()
+		})
+		return err
+	}
 
-			if shouldUpdate {
+	// Verify checksum if one is configured; dedup needs it either way
+	var sum string
+	if entry.SHA256 != "" || opts.Dedup || opts.WriteMeta {
+		if segmented || offset > 0 {
+			// Segments arrive out of order and a resumed download only
+			// streamed its tail, so hash the assembled file
+			hasher, err = hashFile(tmpPath)
+			if err != nil {
+				os.Remove(tmpPath)
 				d.updateProgress(entry.ID, func(p *Progress) {
-					p.Downloaded = downloaded
-					p.Percent = percent
-					p.Speed = speed
+					p.Status = "error"
+					p.Error = err.Error()
 				})
-				lastUpdate = now
-				lastPercent = percent
+				return err
 			}
 		}
-
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			file.Close()
+		sum = hex.EncodeToString(hasher.Sum(nil))
+	}
+	if entry.SHA256 != "" {
+		expected := strings.TrimSpace(entry.SHA256)
+		if !strings.EqualFold(sum, expected) {
 			os.Remove(tmpPath)
+			err := fmt.Errorf("checksum mismatch: expected %s, got %s", strings.ToLower(expected), sum)
 			d.updateProgress(entry.ID, func(p *Progress) {
 				p.Status = "error"
 				p.Error = err.Error()
@@ -286,8 +970,6 @@ func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootD
 		}
 	}
 
-	file.Close()
-
 	// Rename temp file to final
 	if err := os.Rename(tmpPath, fullPath); err != nil {
 		os.Remove(tmpPath)
@@ -298,27 +980,393 @@ func (d *Downloader) Download(ctx context.Context, entry config.FileEntry, rootD
 		return err
 	}
 
+	if opts.Refresh || opts.WriteMeta {
+		meta := FileMeta{
+			URL:          entry.URL,
+			Config:       opts.ConfigName,
+			DownloadedAt: time.Now().UTC(),
+			Size:         downloaded,
+			SHA256:       sum,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if err := writeFileMeta(fullPath, meta); err != nil {
+			logger.Warn("failed to write file metadata", "error", err)
+		}
+	}
+
+	if opts.Dedup {
+		d.dedupe(logger, rootDir, fullPath, sum)
+	}
+
+	// Encoded and chunked responses only reveal the size at the end
 	d.updateProgress(entry.ID, func(p *Progress) {
 		p.Status = "completed"
 		p.Percent = 100
 		p.Downloaded = downloaded
+		p.Total = downloaded
+		p.ETASeconds = 0
 	})
 
 	return nil
 }
 
-// Cancel cancels a download
+// recordResult logs how a download ended, counts it in the metrics and
+// notifies the webhook of completions and failures
+func (d *Downloader) recordResult(logger *slog.Logger, entry config.FileEntry, opts DownloadOptions, elapsed time.Duration, err error) {
+	d.mu.RLock()
+	var status string
+	var downloaded int64
+	if p, ok := d.progress[entry.ID]; ok {
+		status, downloaded = p.Status, p.Downloaded
+	}
+	d.mu.RUnlock()
+
+	if err == nil && status != "unchanged" {
+		status = "completed"
+	}
+	if status != "paused" {
+		d.metrics.finish(status, elapsed, downloaded)
+	}
+	if opts.batch != nil {
+		opts.batch.add(entry.ID, status, downloaded)
+	}
+
+	attrs := []any{"bytes", downloaded, "duration", elapsed.Round(time.Millisecond)}
+	switch {
+	case err == nil:
+		logger.Info("download finished", attrs...)
+	case status == "cancelled":
+		logger.Info("download cancelled", attrs...)
+	case status == "paused":
+		logger.Info("download paused", attrs...)
+	default:
+		logger.Error("download failed", append(attrs, "error", err)...)
+	}
+
+	if d.history != nil && status != "paused" {
+		record := HistoryRecord{
+			Time:     time.Now(),
+			FileID:   entry.ID,
+			FileName: entry.FileName,
+			Folder:   entry.Folder,
+			Config:   opts.ConfigName,
+			Host:     urlHost(entry.URL),
+			Status:   status,
+			Bytes:    downloaded,
+			Duration: elapsed.Seconds(),
+		}
+		if opts.batch != nil {
+			record.BatchID = opts.batch.summary.BatchID
+		}
+		if err != nil && status != "cancelled" {
+			record.Status = "error"
+			record.Error = err.Error()
+		}
+		d.history.add(record)
+	}
+
+	webhookURL := opts.WebhookURL
+	if webhookURL == "" {
+		webhookURL = d.webhook
+	}
+	if webhookURL == "" || opts.skipWebhook || status == "cancelled" || status == "paused" || status == "unchanged" {
+		return
+	}
+	payload := webhookPayload{
+		FileID:   entry.ID,
+		FileName: entry.FileName,
+		Config:   opts.ConfigName,
+		Status:   "completed",
+		Size:     downloaded,
+		Duration: elapsed.Seconds(),
+	}
+	if err != nil {
+		payload.Status = "error"
+		payload.Error = err.Error()
+	}
+	d.notifyWebhook(webhookURL, payload)
+}
+
+// urlHost returns just the host of rawURL, so paths and query tokens stay out of logs
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Host
+}
+
+// entryRequest returns the URL and headers used to fetch an entry, adding
+// the entry's own token, or the config's for entries with UseToken. Basic
+// auth credentials replace a bearer token, and an Authorization header set
+// in the entry's custom headers replaces both, as a User-Agent there
+// replaces userAgent.
+func entryRequest(entry config.FileEntry, token, hfToken, userAgent string) (string, http.Header) {
+	requestURL := entry.URL
+	headers := make(http.Header)
+	headers.Set("User-Agent", userAgent)
+	if entry.Token != "" {
+		requestURL = applyEntryToken(entry.URL, entry.Token, headers)
+	} else if entry.TokenEnabled() {
+		if IsHuggingFaceURL(entry.URL) {
+			if hfToken != "" {
+				headers.Set("Authorization", "Bearer "+hfToken)
+			}
+		} else if token != "" {
+			requestURL = appendToken(entry.URL, token)
+		}
+	}
+	setBasicAuth(headers, entry.Username, entry.Password)
+	applyHeaders(headers, entry.Headers)
+	applyCookies(headers, entry.Cookies)
+	return requestURL, headers
+}
+
+// copyWithProgress copies src to dst, reporting every chunk to the tracker
+func copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, tracker *progressTracker) (int64, error) {
+	buf := make([]byte, BufferSize)
+	var written int64
+	for {
+		select {
+		case <-ctx.Done():
+			return written, ctx.Err()
+		default:
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				return written, writeErr
+			}
+			written += int64(n)
+			tracker.add(int64(n))
+		}
+
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// hashFile computes the SHA256 of a file on disk
+func hashFile(path string) (hash.Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+	return h, nil
+}
+
+// checkDiskSpace fails if dir's filesystem can't hold size more bytes plus a safety margin.
+// Unknown sizes and filesystems that can't report free space are not checked.
+func checkDiskSpace(dir string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		return nil
+	}
+	need := uint64(size) + diskSpaceMargin
+	if free < need {
+		return fmt.Errorf("insufficient disk space: need %s, have %s", formatBytes(int64(need)), formatBytes(int64(free)))
+	}
+	return nil
+}
+
+// formatBytes renders a byte count in human-readable units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit && exp < 4; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// newDownloadRequest builds a GET request for a download URL with the given headers
+func newDownloadRequest(ctx context.Context, downloadURL string, headers http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	for key, values := range headers {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity") // See encoding.go
+	}
+	return req, nil
+}
+
+// Cancel cancels a download. A paused download has its temp file removed
+// and a scheduled one is dropped from its batch.
 func (d *Downloader) Cancel(fileID string) {
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	if cancel, ok := d.cancelFns[fileID]; ok {
 		cancel()
+		d.mu.Unlock()
+		return
+	}
+	if d.unstarted[fileID] > 0 {
+		delete(d.unstarted, fileID)
+		d.markCancelled(fileID)
+		d.mu.Unlock()
+		return
+	}
+	if paused, ok := d.paused[fileID]; ok {
+		delete(d.paused, fileID)
+		delete(d.tempFiles, paused.tmpPath)
+		os.Remove(paused.tmpPath)
+		d.metrics.cancelled.Add(1)
+		d.markCancelled(fileID)
+	}
+	unscheduled := d.cancelScheduledEntry(fileID)
+	d.mu.Unlock()
+
+	if unscheduled {
+		d.saveSchedules()
 	}
 }
 
+// CancelAll cancels every active and scheduled download and returns the
+// cancelled file IDs
+func (d *Downloader) CancelAll() []string {
+	d.mu.Lock()
+	cancelled := append(d.cancelRunning(), d.cancelUnstarted()...)
+	cancelled = append(cancelled, d.cancelAllScheduled()...)
+	d.mu.Unlock()
+	d.saveSchedules()
+	sort.Strings(cancelled)
+	return cancelled
+}
+
+// cancelRunning cancels every active download and returns their file IDs.
+// Must be called with d.mu held.
+func (d *Downloader) cancelRunning() []string {
+	cancelled := make([]string, 0, len(d.cancelFns))
+	for fileID, cancel := range d.cancelFns {
+		cancel()
+		cancelled = append(cancelled, fileID)
+	}
+	return cancelled
+}
+
+// markCancelled sets a download that isn't running to cancelled. Must be
+// called with d.mu held.
+func (d *Downloader) markCancelled(fileID string) {
+	if p, ok := d.progress[fileID]; ok {
+		p.Status = "cancelled"
+		d.trackFinished(p)
+		d.dirty = true
+		d.broadcast(*p)
+	}
+}
+
+// ErrShuttingDown is returned by Download once Shutdown has been called
+var ErrShuttingDown = errors.New("downloader is shutting down")
+
+// ErrAlreadyRunning is returned by Download for a file ID that is already
+// being downloaded
+var ErrAlreadyRunning = errors.New("download is already running")
+
+// ErrPathInUse is returned by Download when another download, running or
+// paused, writes to the same destination
+var ErrPathInUse = errors.New("download already in progress for this path")
+
+// Wait blocks until no downloads are running or ctx is done
+func (d *Downloader) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting downloads, cancels the running ones and waits for
+// them to clean up their temp files, then saves progress. Returns ctx's error
+// if downloads are still running when it's done.
+func (d *Downloader) Shutdown(ctx context.Context) error {
+	// Scheduled batches stay saved for the next run
+	d.mu.Lock()
+	d.closing = true
+	d.cancelRunning()
+	d.mu.Unlock()
+
+	err := d.Wait(ctx)
+
+	if d.statePath != "" {
+		if saveErr := d.saveState(); saveErr != nil {
+			d.logger.Warn("failed to save download progress", "path", d.statePath, "error", saveErr)
+		}
+	}
+	return err
+}
+
+// CleanTempFiles removes leftover .tmp files under rootDir that no active or
+// paused download is using. Returns the removed paths relative to the root.
+func (d *Downloader) CleanTempFiles(rootDir string) (removed []string, err error) {
+	root, err := config.ResolveRoot(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed = []string{}
+	err = filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip inaccessible paths
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tmp") {
+			return nil
+		}
+
+		// Hold the lock so a download can't claim the file while we remove it
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, active := d.tempFiles[path]; active {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return nil
+		}
+		if rel, err := filepath.Rel(root, path); err == nil {
+			removed = append(removed, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to scan directory: %w", err)
+	}
+	return removed, nil
+}
+
 // DeleteFile deletes a file from disk
 func (d *Downloader) DeleteFile(rootDir, folder, fileName string) error {
-	fullPath := filepath.Join(config.ExpandPath(rootDir), folder, fileName)
+	fullPath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return err
+	}
+	os.Remove(metaPath(fullPath))
 	if err := os.Remove(fullPath); err != nil {
 		if os.IsNotExist(err) {
 			return nil // Already deleted
@@ -328,10 +1376,153 @@ func (d *Downloader) DeleteFile(rootDir, folder, fileName string) error {
 	return nil
 }
 
+// FileRef names a file by folder and name under a root directory
+type FileRef struct {
+	Folder   string `json:"folder"`
+	FileName string `json:"fileName"`
+}
+
+// DeleteResult is the outcome of deleting one file with DeleteFiles
+type DeleteResult struct {
+	FileRef
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteFiles moves each file to the trash like TrashFile, or deletes it
+// like DeleteFile when permanent is set, carrying on past failures and
+// reporting every outcome. With removeEmptyFolders, folders left empty are
+// removed too, up to but not including the root.
+func (d *Downloader) DeleteFiles(rootDir string, files []FileRef, permanent, removeEmptyFolders bool) []DeleteResult {
+	remove := d.TrashFile
+	if permanent {
+		remove = d.DeleteFile
+	}
+	results := make([]DeleteResult, len(files))
+	for i, f := range files {
+		results[i].FileRef = f
+		if err := remove(rootDir, f.Folder, f.FileName); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Deleted = true
+
+		if removeEmptyFolders {
+			if root, err := config.ResolveRoot(rootDir); err == nil {
+				if path, err := config.EntryPath(rootDir, f.Folder, f.FileName); err == nil {
+					removeEmptyParents(root, filepath.Dir(path))
+				}
+			}
+		}
+	}
+	return results
+}
+
+// removeEmptyParents removes dir and its parents while they are empty,
+// stopping at root
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		// Fails, and stops the climb, once a folder still has something in it
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// MoveFile moves or renames a downloaded file within the root directory,
+// creating the destination folder if needed. An existing destination is
+// only replaced when force is set.
+func (d *Downloader) MoveFile(rootDir, srcFolder, srcName, dstFolder, dstName string, force bool) error {
+	srcPath, err := config.SafeJoin(rootDir, srcFolder, srcName)
+	if err != nil {
+		return err
+	}
+	dstPath, err := config.SafeJoin(rootDir, dstFolder, dstName)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("source file not found: %s", filepath.Join(srcFolder, srcName))
+		}
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("source is a directory: %s", filepath.Join(srcFolder, srcName))
+	}
+	if srcPath == dstPath {
+		return nil
+	}
+
+	if _, err := os.Stat(dstPath); err == nil && !force {
+		return fmt.Errorf("destination %s: %w", filepath.Join(dstFolder, dstName), os.ErrExist)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		// Rename fails across filesystems, fall back to copy and delete
+		if err := copyFile(srcPath, dstPath, info.Mode()); err != nil {
+			return fmt.Errorf("failed to move file: %w", err)
+		}
+		if err := os.Remove(srcPath); err != nil {
+			return fmt.Errorf("file copied but source not removed: %w", err)
+		}
+	}
+
+	// The sidecar follows its file; a stale one at the destination goes
+	if meta, err := os.ReadFile(metaPath(srcPath)); err == nil {
+		if err := config.WriteFileAtomic(metaPath(dstPath), meta, 0644); err == nil {
+			os.Remove(metaPath(srcPath))
+		}
+	} else {
+		os.Remove(metaPath(dstPath))
+	}
+	return nil
+}
+
+// copyFile copies src to dst via a temp file so dst is never left half-written
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := dst + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
 func (d *Downloader) updateProgress(fileID string, fn func(p *Progress)) {
 	d.mu.Lock()
 	if p, ok := d.progress[fileID]; ok {
 		fn(p)
+		p.ElapsedSeconds = d.elapsed(p)
+		d.trackFinished(p)
+		d.trackPeakSpeed()
+		d.dirty = true
 		// Broadcast update
 		d.broadcast(*p)
 	}
@@ -355,54 +1546,207 @@ func appendToken(rawURL string, token string) string {
 	return parsed.String()
 }
 
+// applyEntryToken adds a per-entry token the way its host expects it: as
+// the token query parameter for Civitai, as a bearer header anywhere else.
+// It returns the URL to request.
+func applyEntryToken(rawURL, token string, headers http.Header) string {
+	if IsCivitaiURL(rawURL) {
+		return appendToken(rawURL, token)
+	}
+	headers.Set("Authorization", "Bearer "+token)
+	return rawURL
+}
+
 // IsCivitaiURL checks if URL is from civitai.com
 func IsCivitaiURL(rawURL string) bool {
+	return isDomainURL(rawURL, "civitai.com")
+}
+
+// IsHuggingFaceURL checks if URL is from huggingface.co
+func IsHuggingFaceURL(rawURL string) bool {
+	return isDomainURL(rawURL, "huggingface.co")
+}
+
+// isDomainURL reports whether rawURL's host is domain or one of its
+// subdomains. Look-alikes such as domain.evil.com don't match, so site
+// tokens only go to the site itself.
+func isDomainURL(rawURL, domain string) bool {
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
-		return strings.Contains(strings.ToLower(rawURL), "civitai.com")
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// applyHeaders copies custom headers into h, replacing any existing values
+func applyHeaders(h http.Header, custom map[string]string) {
+	for key, value := range custom {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		h.Set(key, value)
+	}
+}
+
+// setBasicAuth sets the Authorization header for HTTP Basic Auth, if username is set
+func setBasicAuth(h http.Header, username, password string) {
+	if username == "" {
+		return
+	}
+	req := http.Request{Header: h}
+	req.SetBasicAuth(username, password)
+}
+
+// applyCookies adds cookies to the Cookie header, after any set in custom headers
+func applyCookies(h http.Header, cookies map[string]string) {
+	if len(cookies) == 0 {
+		return
+	}
+	names := make([]string, 0, len(cookies))
+	for name := range cookies {
+		names = append(names, name)
 	}
-	return strings.Contains(strings.ToLower(parsed.Host), "civitai.com")
+	sort.Strings(names)
+
+	var parts []string
+	if existing := h.Get("Cookie"); existing != "" {
+		parts = append(parts, existing)
+	}
+	for _, name := range names {
+		if c := (&http.Cookie{Name: name, Value: cookies[name]}).String(); c != "" {
+			parts = append(parts, c)
+		}
+	}
+	h.Set("Cookie", strings.Join(parts, "; "))
+}
+
+// FileInfoOptions holds auth and transport settings for GetFileInfoFromURL
+type FileInfoOptions struct {
+	Token    string            // Civitai token, appended to civitai.com URLs
+	HFToken  string            // HuggingFace token, sent as a bearer header
+	Headers  map[string]string // Custom headers, applied last so they can override the defaults
+	Cookies  map[string]string // Sent in the Cookie header
+	Username string            // HTTP Basic Auth user, takes precedence over HFToken
+	Password string
+	ProxyURL string // Explicit proxy, empty uses the environment
+
+	EntryToken string // Token of the file entry, replaces Token and HFToken
 }
 
-// GetFileInfoFromURL fetches filename from URL using HEAD request
-func GetFileInfoFromURL(targetURL string, token string) (fileName string, fileSize int64) {
-	// Build URL with token if it's civitai
+// FileInfo is what GetFileInfoFromURL learned about a remote file
+type FileInfo struct {
+	FileName   string // From Content-Disposition, falling back to the URL path
+	Size       int64  // -1 if unknown
+	StatusCode int    // Status of the last response, 0 if the server couldn't be reached
+	Reachable  bool   // The server answered with a 2xx status
+	Error      string // Why the file isn't reachable
+}
+
+// GetFileInfoFromURL fetches filename from URL using HEAD request. A dead
+// link is reported in the FileInfo; the error is only for invalid options.
+func (d *Downloader) GetFileInfoFromURL(targetURL string, opts FileInfoOptions) (FileInfo, error) {
 	requestURL := targetURL
-	if token != "" && IsCivitaiURL(targetURL) {
-		requestURL = appendToken(targetURL, token)
+	headers := make(http.Header)
+	headers.Set("User-Agent", d.userAgent)
+	switch {
+	case opts.EntryToken != "":
+		requestURL = applyEntryToken(targetURL, opts.EntryToken, headers)
+	case opts.Token != "" && IsCivitaiURL(targetURL):
+		// Build URL with token if it's civitai
+		requestURL = appendToken(targetURL, opts.Token)
+	case opts.HFToken != "" && IsHuggingFaceURL(targetURL):
+		// HuggingFace expects a bearer header instead
+		headers.Set("Authorization", "Bearer "+opts.HFToken)
 	}
+	setBasicAuth(headers, opts.Username, opts.Password)
+	applyHeaders(headers, opts.Headers)
+	applyCookies(headers, opts.Cookies)
 
-	client := &http.Client{
-		Timeout: 15 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
+	client, err := d.infoClient(opts.ProxyURL)
+	if err != nil {
+		return FileInfo{}, err
 	}
 
 	// Try HEAD request first
-	fileName, fileSize = tryGetFileInfo(client, "HEAD", requestURL)
-	if fileName != "" && !looksLikeID(fileName) {
-		return fileName, fileSize
+	head, headErr := probeURL(client, "HEAD", requestURL, headers)
+	if headErr == nil && probeOK(head) && head.fileName != "" && !looksLikeID(head.fileName) {
+		return probeInfo(head, nil, targetURL), nil
 	}
 
 	// For civitai and other sites that don't support HEAD properly,
 	// try GET with Range header to get just the headers
-	fileName, fileSize = tryGetFileInfo(client, "GET", requestURL)
-	if fileName != "" && !looksLikeID(fileName) {
-		return fileName, fileSize
+	probe, err := probeURL(client, "GET", requestURL, headers)
+	if (err != nil || !probeOK(probe)) && headErr == nil && probeOK(head) {
+		probe, err = head, nil // HEAD worked, it just had no filename
+	}
+	return probeInfo(probe, err, targetURL), nil
+}
+
+// probeOK reports whether the probed server answered with a 2xx status
+func probeOK(probe urlProbe) bool {
+	return probe.statusCode >= 200 && probe.statusCode < 300
+}
+
+// probeInfo turns a probe result into a FileInfo, naming the file after
+// the URL path when the server didn't suggest a usable name
+func probeInfo(probe urlProbe, err error, targetURL string) FileInfo {
+	info := FileInfo{
+		FileName:   extractFileNameFromURL(targetURL),
+		Size:       probe.size,
+		StatusCode: probe.statusCode,
+		Reachable:  err == nil && probeOK(probe),
+	}
+	switch {
+	case err != nil:
+		info.Error = err.Error()
+	case !info.Reachable:
+		info.Error = fmt.Sprintf("bad status: %d %s", probe.statusCode, http.StatusText(probe.statusCode))
+		info.Size = -1 // Error pages say nothing about the file
+	case probe.fileName != "" && !looksLikeID(probe.fileName):
+		info.FileName = probe.fileName
+	}
+	return info
+}
+
+// fileInfoTimeout bounds a metadata request, including redirects
+const fileInfoTimeout = 15 * time.Second
+
+// HTTPClient returns a client for outbound requests other than downloads,
+// such as config imports. It shares the downloads' transport and redirect
+// policy, so the internal address guard, proxy and TLS settings apply.
+func (d *Downloader) HTTPClient() *http.Client {
+	c := *d.client
+	return &c
+}
+
+// infoClient returns a short-timeout client for metadata requests. It
+// shares the downloads' transport, so proxy, timeouts, connection pool,
+// internal address guard and redirect policy are the same.
+func (d *Downloader) infoClient(proxyURL string) (*http.Client, error) {
+	client, err := d.clientFor(proxyURL)
+	if err != nil {
+		return nil, err
 	}
+	client = withCookieJar(client)
+	client.Timeout = fileInfoTimeout
+	return client, nil
+}
 
-	// Fallback to URL path
-	return extractFileNameFromURL(targetURL), fileSize
+// urlProbe is what a HEAD or ranged GET revealed about a remote file
+type urlProbe struct {
+	statusCode int
+	fileName   string // From Content-Disposition, empty if not sent
+	size       int64  // -1 if unknown
 }
 
-func tryGetFileInfo(client *http.Client, method string, targetURL string) (fileName string, fileSize int64) {
+// probeURL requests targetURL's headers without downloading the body
+func probeURL(client *http.Client, method string, targetURL string, headers http.Header) (urlProbe, error) {
+	probe := urlProbe{size: -1}
+
 	req, err := http.NewRequest(method, targetURL, nil)
 	if err != nil {
-		return "", 0
+		return probe, redactError(err)
 	}
 
 	// Add Range header for GET to avoid downloading entire file
@@ -410,42 +1754,71 @@ func tryGetFileInfo(client *http.Client, method string, targetURL string) (fileN
 		req.Header.Set("Range", "bytes=0-0")
 	}
 
-	// Add User-Agent to avoid being blocked
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	// Caller headers, including the User-Agent, go last so they can override the Range above
+	for key, values := range headers {
+		req.Header[key] = values
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", 0
+		return probe, redactError(err)
 	}
 	defer resp.Body.Close()
 
-	// Drain the body to allow connection reuse
-	io.Copy(io.Discard, resp.Body)
+	// Drain a little of the body to allow connection reuse, servers that
+	// ignore the Range header would otherwise send the whole file
+	io.CopyN(io.Discard, resp.Body, BufferSize)
 
-	fileSize = resp.ContentLength
-	if fileSize < 0 {
-		// Try Content-Range header for Range requests
+	probe.statusCode = resp.StatusCode
+	probe.size = resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		probe.size = -1
+		// Format: bytes 0-0/totalsize
 		contentRange := resp.Header.Get("Content-Range")
-		if contentRange != "" {
-			// Format: bytes 0-0/totalsize
-			if idx := strings.LastIndex(contentRange, "/"); idx != -1 {
-				if size, err := strconv.ParseInt(contentRange[idx+1:], 10, 64); err == nil {
-					fileSize = size
-				}
+		if idx := strings.LastIndex(contentRange, "/"); idx != -1 {
+			if size, err := strconv.ParseInt(contentRange[idx+1:], 10, 64); err == nil {
+				probe.size = size
 			}
 		}
 	}
 
 	// Try to get filename from Content-Disposition header
-	contentDisposition := resp.Header.Get("Content-Disposition")
-	if contentDisposition != "" {
-		fileName = parseContentDisposition(contentDisposition)
-		if fileName != "" {
-			return fileName, fileSize
-		}
+	if contentDisposition := resp.Header.Get("Content-Disposition"); contentDisposition != "" {
+		probe.fileName = parseContentDisposition(contentDisposition)
+	}
+	return probe, nil
+}
+
+// remoteSizeMatches reports whether the server's size of entry equals
+// size. An unknown remote size, or a failed probe, counts as a match: the
+// file is kept rather than replaced by a download that may not work.
+func (d *Downloader) remoteSizeMatches(entry config.FileEntry, opts DownloadOptions, size int64, logger *slog.Logger) bool {
+	client, err := d.infoClient(opts.ProxyURL)
+	if err != nil {
+		return true
+	}
+	check := d.checkURL(client, entry, FileInfoOptions{Token: opts.Token, HFToken: opts.HFToken, ProxyURL: opts.ProxyURL})
+	if check.Size < 0 {
+		logger.Debug("remote size unknown, keeping existing file", "fileName", entry.FileName, "error", check.Error)
+		return true
 	}
+	return check.Size == size
+}
 
-	return "", fileSize
+// isPlaceholderName reports whether an entry's file name is missing or just
+// an ID, so AutoName may replace it with the server's suggestion
+func isPlaceholderName(name string) bool {
+	return name == "" || looksLikeID(name)
+}
+
+// suggestedFileName returns the file name from resp's Content-Disposition,
+// or "" if there is none or it is just an ID
+func suggestedFileName(resp *http.Response) string {
+	name := parseContentDisposition(resp.Header.Get("Content-Disposition"))
+	if looksLikeID(name) {
+		return ""
+	}
+	return name
 }
 
 // looksLikeID checks if filename looks like just an ID (numbers only)
@@ -463,40 +1836,6 @@ func looksLikeID(name string) bool {
 	return len(name) > 0
 }
 
-// parseContentDisposition extracts filename from Content-Disposition header
-func parseContentDisposition(header string) string {
-	// Handle: attachment; filename="file.zip"
-	// Handle: attachment; filename=file.zip
-	// Handle: attachment; filename*=UTF-8''file.zip
-
-	parts := strings.Split(header, ";")
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-
-		// Check for filename*= (RFC 5987)
-		if strings.HasPrefix(strings.ToLower(part), "filename*=") {
-			value := part[10:]
-			// Handle UTF-8 encoding like: UTF-8''filename.ext
-			if idx := strings.Index(value, "''"); idx != -1 {
-				encoded := value[idx+2:]
-				decoded, err := url.PathUnescape(encoded)
-				if err == nil {
-					return decoded
-				}
-				return encoded
-			}
-			return strings.Trim(value, "\"'")
-		}
-
-		// Check for filename=
-		if strings.HasPrefix(strings.ToLower(part), "filename=") {
-			value := part[9:]
-			return strings.Trim(value, "\"'")
-		}
-	}
-	return ""
-}
-
 // extractFileNameFromURL extracts filename from URL path
 func extractFileNameFromURL(rawURL string) string {
 	parsed, err := url.Parse(rawURL)
@@ -523,177 +1862,3 @@ func extractFileNameFromURL(rawURL string) string {
 	}
 	return ""
 }
-
-// IsArchive checks if file is an archive based on extension
-func IsArchive(fileName string) bool {
-	lower := strings.ToLower(fileName)
-	return strings.HasSuffix(lower, ".zip") ||
-		strings.HasSuffix(lower, ".tar") ||
-		strings.HasSuffix(lower, ".tar.gz") ||
-		strings.HasSuffix(lower, ".tgz")
-}
-
-// ExtractedFileInfo contains info about extracted file
-type ExtractedFileInfo struct {
-	Name string `json:"name"`
-	Size int64  `json:"size"`
-}
-
-// ExtractArchive extracts an archive and returns list of extracted files with sizes
-func (d *Downloader) ExtractArchive(rootDir, folder, fileName string) ([]ExtractedFileInfo, error) {
-	archivePath := filepath.Join(config.ExpandPath(rootDir), folder, fileName)
-	extractDir := filepath.Join(config.ExpandPath(rootDir), folder)
-
-	lower := strings.ToLower(fileName)
-
-	if strings.HasSuffix(lower, ".zip") {
-		return extractZip(archivePath, extractDir)
-	}
-
-	if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
-		return extractTarGz(archivePath, extractDir)
-	}
-
-	if strings.HasSuffix(lower, ".tar") {
-		return extractTar(archivePath, extractDir)
-	}
-
-	return nil, fmt.Errorf("unsupported archive format")
-}
-
-// DeleteExtractedFile deletes an extracted file from disk
-func (d *Downloader) DeleteExtractedFile(rootDir, folder, fileName string) error {
-	fullPath := filepath.Join(config.ExpandPath(rootDir), folder, fileName)
-	return os.Remove(fullPath)
-}
-
-func extractZip(archivePath, extractDir string) ([]ExtractedFileInfo, error) {
-	r, err := zip.OpenReader(archivePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open zip: %w", err)
-	}
-	defer r.Close()
-
-	var extracted []ExtractedFileInfo
-
-	for _, f := range r.File {
-		// Skip directories
-		if f.FileInfo().IsDir() {
-			continue
-		}
-
-		// Security: prevent path traversal
-		destPath := filepath.Join(extractDir, f.Name)
-		if !strings.HasPrefix(destPath, filepath.Clean(extractDir)+string(os.PathSeparator)) {
-			continue // Skip files that would escape extract directory
-		}
-
-		// Create directory structure
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			return extracted, err
-		}
-
-		// Extract file
-		rc, err := f.Open()
-		if err != nil {
-			return extracted, err
-		}
-
-		outFile, err := os.Create(destPath)
-		if err != nil {
-			rc.Close()
-			return extracted, err
-		}
-
-		written, err := io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
-
-		if err != nil {
-			return extracted, err
-		}
-
-		extracted = append(extracted, ExtractedFileInfo{
-			Name: f.Name,
-			Size: written,
-		})
-	}
-
-	return extracted, nil
-}
-
-func extractTarGz(archivePath, extractDir string) ([]ExtractedFileInfo, error) {
-	file, err := os.Open(archivePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	gzr, err := gzip.NewReader(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-	}
-	defer gzr.Close()
-
-	return extractTarReader(tar.NewReader(gzr), extractDir)
-}
-
-func extractTar(archivePath, extractDir string) ([]ExtractedFileInfo, error) {
-	file, err := os.Open(archivePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	return extractTarReader(tar.NewReader(file), extractDir)
-}
-
-func extractTarReader(tr *tar.Reader, extractDir string) ([]ExtractedFileInfo, error) {
-	var extracted []ExtractedFileInfo
-
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return extracted, err
-		}
-
-		// Skip directories
-		if header.Typeflag == tar.TypeDir {
-			continue
-		}
-
-		// Security: prevent path traversal
-		destPath := filepath.Join(extractDir, header.Name)
-		if !strings.HasPrefix(destPath, filepath.Clean(extractDir)+string(os.PathSeparator)) {
-			continue
-		}
-
-		// Create directory structure
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			return extracted, err
-		}
-
-		// Extract file
-		outFile, err := os.Create(destPath)
-		if err != nil {
-			return extracted, err
-		}
-
-		written, err := io.Copy(outFile, tr)
-		outFile.Close()
-
-		if err != nil {
-			return extracted, err
-		}
-
-		extracted = append(extracted, ExtractedFileInfo{
-			Name: header.Name,
-			Size: written,
-		})
-	}
-
-	return extracted, nil
-}
@@ -0,0 +1,322 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// discardLogger returns a logger that drops everything, for tests and
+// benchmarks running many downloads
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestConcurrentDownloadsToSamePath(t *testing.T) {
+	setAllowInternal(t, true)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		if r.Method != http.MethodGet {
+			return
+		}
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	d := NewDownloader(DownloaderOptions{})
+	entry := func(id string) config.FileEntry {
+		return config.FileEntry{ID: id, URL: srv.URL + "/file", FileName: "file.bin"}
+	}
+
+	// The first download holds the path until the server answers
+	first := make(chan error, 1)
+	go func() {
+		first <- d.Download(context.Background(), entry("a"), root, DownloadOptions{})
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.Download(context.Background(), entry("b"), root, DownloadOptions{Force: true})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if !errors.Is(err, ErrPathInUse) {
+			t.Fatalf("second download: got error %v, want ErrPathInUse", err)
+		}
+	}
+	if p, _ := d.GetProgress("b"); p.Status != "error" {
+		t.Errorf("refused download has status %q, want error", p.Status)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "file.bin"))
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("file contents %q, %v", data, err)
+	}
+
+	// The path is free again once the first download is done
+	if err := d.Download(context.Background(), entry("b"), root, DownloadOptions{Force: true}); err != nil {
+		t.Fatalf("download after the first finished: %v", err)
+	}
+}
+
+func TestProgressSnapshotsUnderConcurrentUpdates(t *testing.T) {
+	d := NewDownloader(DownloaderOptions{})
+	ids := []string{"a", "b", "c", "d"}
+	d.mu.Lock()
+	for _, id := range ids {
+		d.progress[id] = &Progress{FileID: id, Status: "downloading", Total: 1000, StartedAt: time.Now()}
+		d.cancelFns[id] = func() {}
+	}
+	d.mu.Unlock()
+
+	ch := d.Subscribe()
+	defer d.Unsubscribe(ch)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for n := int64(1); ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				d.updateProgress(id, func(p *Progress) {
+					p.Downloaded = n % 1000
+					p.Percent = float64(p.Downloaded) / 10
+					p.Speed = float64(n)
+				})
+			}
+		}(id)
+	}
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				for _, id := range ids {
+					p, ok := d.GetProgress(id)
+					if !ok {
+						t.Errorf("no progress for %s", id)
+						return
+					}
+					// A snapshot taken in the middle of an update would
+					// pair one update's bytes with another's percent
+					if p.Percent != float64(p.Downloaded)/10 {
+						t.Errorf("inconsistent snapshot: %+v", p)
+						return
+					}
+					p.Status = "tampered" // Must not reach the map
+				}
+				for id, p := range d.GetAllProgress() {
+					if p.FileID != id || p.Status != "downloading" {
+						t.Errorf("bad snapshot for %s: %+v", id, p)
+						return
+					}
+				}
+			}
+		}()
+	}
+	time.Sleep(250 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+func TestDownloadSameIDTwice(t *testing.T) {
+	setAllowInternal(t, true)
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "3")
+		if r.Method != http.MethodGet {
+			return
+		}
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("abc"))
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	d := NewDownloader(DownloaderOptions{})
+	entry := config.FileEntry{ID: "a", URL: srv.URL + "/file", FileName: "file.bin"}
+	first := make(chan error, 1)
+	go func() {
+		first <- d.Download(context.Background(), entry, root, DownloadOptions{})
+	}()
+	<-started
+
+	if err := d.Download(context.Background(), entry, root, DownloadOptions{Force: true}); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("got error %v, want ErrAlreadyRunning", err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStuckListenerIsReaped(t *testing.T) {
+	d := NewDownloader(DownloaderOptions{})
+	stuck := d.Subscribe() // Never drained
+	active := d.Subscribe()
+
+	// Every event must reach the active listener while the stuck one fills up
+	for i := 0; i < cap(stuck)+maxListenerDrops; i++ {
+		d.broadcast(Progress{FileID: "a", Downloaded: int64(i)})
+		select {
+		case p := <-active:
+			if p.Downloaded != int64(i) {
+				t.Fatalf("active listener got event %d, want %d", p.Downloaded, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("active listener missed event %d", i)
+		}
+	}
+
+	// The stuck channel is closed once its buffered events are read
+	for i := 0; i < cap(stuck); i++ {
+		<-stuck
+	}
+	if _, ok := <-stuck; ok {
+		t.Fatal("stuck listener was not closed")
+	}
+	d.listenerMu.Lock()
+	n := len(d.listeners)
+	d.listenerMu.Unlock()
+	if n != 1 {
+		t.Errorf("%d listeners left, want 1", n)
+	}
+
+	// Unsubscribing a reaped listener must not close its channel twice
+	d.Unsubscribe(stuck)
+	d.Unsubscribe(active)
+}
+
+func TestCheckFileStatus(t *testing.T) {
+	const sum = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	const other = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	tests := []struct {
+		name     string
+		size     int // Of the file, -1 for none
+		tmp      int // Of the .tmp next to it, -1 for none
+		meta     *FileMeta
+		running  bool   // A download owns the .tmp
+		wantSize int64  // Expected size passed in
+		wantSum  string // Expected checksum passed in
+		want     FileStatus
+	}{
+		{name: "missing", size: -1, tmp: -1, want: FileStatus{}},
+		{name: "partial only", size: -1, tmp: 4, want: FileStatus{Partial: true, PartialSize: 4}},
+		{name: "in progress", size: -1, tmp: 4, running: true, want: FileStatus{InProgress: true, PartialSize: 4}},
+		{name: "nothing expected", size: 8, tmp: -1, want: FileStatus{Exists: true, Size: 8, Complete: true}},
+		{name: "expected size", size: 8, tmp: -1, wantSize: 8, want: FileStatus{Exists: true, Size: 8, Complete: true}},
+		{name: "wrong size", size: 8, tmp: -1, wantSize: 9, want: FileStatus{Exists: true, Size: 8, SizeMismatch: true}},
+		{name: "checksum without sidecar", size: 8, tmp: -1, wantSum: sum, want: FileStatus{Exists: true, Size: 8}},
+		{name: "checksum confirmed", size: 8, tmp: -1, meta: &FileMeta{Size: 8, SHA256: sum}, wantSum: strings.ToUpper(sum), want: FileStatus{Exists: true, Size: 8, Complete: true}},
+		{name: "checksum differs", size: 8, tmp: -1, meta: &FileMeta{Size: 8, SHA256: other}, wantSum: sum, want: FileStatus{Exists: true, Size: 8, ChecksumMismatch: true}},
+		{name: "sidecar of another size", size: 8, tmp: -1, meta: &FileMeta{Size: 9, SHA256: sum}, wantSum: sum, want: FileStatus{Exists: true, Size: 8}},
+		{name: "sidecar without checksum", size: 8, tmp: -1, meta: &FileMeta{Size: 8}, wantSum: sum, want: FileStatus{Exists: true, Size: 8}},
+		{name: "file and partial", size: 8, tmp: 4, want: FileStatus{Exists: true, Size: 8, Complete: true, Partial: true, PartialSize: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			path := filepath.Join(root, "models", "model.bin")
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.size >= 0 {
+				os.WriteFile(path, make([]byte, tt.size), 0644)
+			}
+			if tt.tmp >= 0 {
+				os.WriteFile(path+".tmp", make([]byte, tt.tmp), 0644)
+			}
+			if tt.meta != nil {
+				if err := writeFileMeta(path, *tt.meta); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := NewDownloader(DownloaderOptions{})
+			if tt.running {
+				d.mu.Lock()
+				d.tempFiles[path+".tmp"] = "a"
+				d.cancelFns["a"] = func() {}
+				d.mu.Unlock()
+			}
+
+			got, err := d.CheckFileStatus(root, "models", "model.bin", tt.wantSize, tt.wantSum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("CheckFileStatus = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSiteURLs(t *testing.T) {
+	tests := []struct {
+		url         string
+		huggingFace bool
+		civitai     bool
+	}{
+		{"https://huggingface.co/org/repo/resolve/main/model.bin", true, false},
+		{"https://HuggingFace.co./org/repo", true, false},
+		{"https://cdn-lfs.huggingface.co/repos/x", true, false},
+		{"https://huggingface.co:443/org/repo", true, false},
+		{"https://huggingface.co.evil.com/org/repo", false, false},
+		{"https://nothuggingface.com.example/x", false, false},
+		{"https://nothuggingface.co/x", false, false},
+		{"https://evil.com/huggingface.co/x", false, false},
+		{"https://evil.com/?u=https://huggingface.co", false, false},
+		{"https://civitai.com/api/download/models/1", false, true},
+		{"https://www.civitai.com/api/download/models/1", false, true},
+		{"https://civitai.com.evil.com/api/download/models/1", false, false},
+		{"https://notcivitai.com/x", false, false},
+		{"://huggingface.co civitai.com", false, false},
+	}
+	for _, tt := range tests {
+		if got := IsHuggingFaceURL(tt.url); got != tt.huggingFace {
+			t.Errorf("IsHuggingFaceURL(%q) = %v, want %v", tt.url, got, tt.huggingFace)
+		}
+		if got := IsCivitaiURL(tt.url); got != tt.civitai {
+			t.Errorf("IsCivitaiURL(%q) = %v, want %v", tt.url, got, tt.civitai)
+		}
+	}
+}
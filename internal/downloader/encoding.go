@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Downloads ask for the file as stored (Accept-Encoding: identity), which
+// also stops the transport from negotiating gzip and decompressing behind
+// our back. Servers that encode the body anyway are decoded here, so what
+// lands on disk, the progress, the size checks and the checksum all refer
+// to the same decoded bytes.
+
+// contentEncoding returns the encoding the response body has to be decoded
+// from before saving, or "" to save it as sent. A gzip file served with
+// "Content-Encoding: gzip" is usually the file itself, labelled by a
+// misconfigured server, so it is kept compressed like browsers do.
+func contentEncoding(resp *http.Response, fileName string) (string, error) {
+	if !isEncoded(resp) {
+		return "", nil
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "gzip", "x-gzip":
+		if isGzipFile(fileName) {
+			return "", nil
+		}
+		return "gzip", nil
+	case "deflate":
+		return "deflate", nil
+	}
+	return "", fmt.Errorf("unsupported Content-Encoding: %s", encoding)
+}
+
+// isEncoded reports whether the response body is sent with a content
+// encoding, so its byte offsets aren't those of the file
+func isEncoded(resp *http.Response) bool {
+	encoding := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	return !resp.Uncompressed && encoding != "" && !strings.EqualFold(encoding, "identity")
+}
+
+// isGzipFile reports whether a file name is itself gzip-compressed
+func isGzipFile(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".gz", ".tgz":
+		return true
+	}
+	return false
+}
+
+// decodedBody reads a response body through its decoder
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// decodeBody wraps body with a decoder for encoding. Closing the result
+// closes body.
+func decodeBody(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gzip response: %w", err)
+		}
+		return decodedBody{zr, body}, nil
+	case "deflate":
+		// "deflate" should be zlib-wrapped, but some servers send raw deflate
+		br := bufio.NewReader(body)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode deflate response: %w", err)
+			}
+			return decodedBody{zr, body}, nil
+		}
+		return decodedBody{flate.NewReader(br), body}, nil
+	}
+	return body, nil
+}
+
+// isZlibHeader reports whether b starts a zlib stream (RFC 1950)
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// encodeBody compresses data the way a server sending encoding would
+func encodeBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return data
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadEncodedResponse(t *testing.T) {
+	setAllowInternal(t, true)
+	content := []byte(strings.Repeat("model weights ", 4096))
+	gzipped := encodeBody(t, "gzip", content)
+
+	tests := []struct {
+		name     string
+		fileName string
+		encoding string // Content-Encoding header sent
+		body     []byte
+		want     []byte // Saved file
+		wantErr  string
+	}{
+		{name: "gzip", fileName: "model.bin", encoding: "gzip", body: gzipped, want: content},
+		{name: "x-gzip", fileName: "model.bin", encoding: "x-gzip", body: gzipped, want: content},
+		{name: "zlib deflate", fileName: "model.bin", encoding: "deflate", body: encodeBody(t, "deflate", content), want: content},
+		{name: "raw deflate", fileName: "model.bin", encoding: "deflate", body: encodeBody(t, "raw-deflate", content), want: content},
+		{name: "gzip file kept compressed", fileName: "model.tar.gz", encoding: "gzip", body: gzipped, want: gzipped},
+		{name: "identity", fileName: "model.bin", encoding: "identity", body: content, want: content},
+		{name: "unsupported", fileName: "model.bin", encoding: "br", body: content, wantErr: "unsupported Content-Encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Accept-Encoding"); got != "identity" {
+					t.Errorf("Accept-Encoding = %q, want identity", got)
+				}
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.Header().Set("Content-Type", "application/octet-stream")
+				if r.Method == http.MethodGet {
+					w.Write(tt.body)
+				}
+			}))
+			defer srv.Close()
+
+			sum := sha256.Sum256(tt.want)
+			entry := config.FileEntry{ID: "a", URL: srv.URL + "/file", FileName: tt.fileName, SHA256: hex.EncodeToString(sum[:])}
+			root := t.TempDir()
+			d := NewDownloader(DownloaderOptions{})
+			err := d.Download(context.Background(), entry, root, DownloadOptions{WriteMeta: true})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Download = %v, want error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+
+			// The checksum passed, and the file, progress and metadata
+			// all describe the saved bytes
+			data, err := os.ReadFile(filepath.Join(root, tt.fileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("saved %d bytes, want %d", len(data), len(tt.want))
+			}
+			p, _ := d.GetProgress("a")
+			if p.Downloaded != int64(len(tt.want)) || p.Total != int64(len(tt.want)) {
+				t.Errorf("progress %d/%d bytes, want %d", p.Downloaded, p.Total, len(tt.want))
+			}
+			meta, err := readFileMeta(filepath.Join(root, tt.fileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Size != int64(len(tt.want)) || meta.SHA256 != entry.SHA256 {
+				t.Errorf("metadata size %d sha %s, want %d %s", meta.Size, meta.SHA256, len(tt.want), entry.SHA256)
+			}
+		})
+	}
+}
+
+func TestDownloadEncodedResponseChecksumMismatch(t *testing.T) {
+	setAllowInternal(t, true)
+	content := []byte(strings.Repeat("model weights ", 4096))
+	gzipped := encodeBody(t, "gzip", content)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped)
+	}))
+	defer srv.Close()
+
+	// The checksum of the encoded bytes isn't the file's
+	sum := sha256.Sum256(gzipped)
+	entry := config.FileEntry{ID: "a", URL: srv.URL + "/file", FileName: "model.bin", SHA256: hex.EncodeToString(sum[:])}
+	root := t.TempDir()
+	d := NewDownloader(DownloaderOptions{})
+	if err := d.Download(context.Background(), entry, root, DownloadOptions{}); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Download = %v, want a checksum mismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "model.bin")); err == nil {
+		t.Error("file with a bad checksum was kept")
+	}
+}
+
+func TestIdleTimeoutOnEncodedResponse(t *testing.T) {
+	setAllowInternal(t, true)
+	gzipped := encodeBody(t, "gzip", []byte(strings.Repeat("model weights ", 4096)))
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Enough for the decoder and the HTML sniff, then nothing
+		w.Write(gzipped[:len(gzipped)/2])
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	d := NewDownloader(DownloaderOptions{IdleTimeout: 200 * time.Millisecond})
+	entry := config.FileEntry{ID: "a", URL: srv.URL + "/file", FileName: "model.bin"}
+	start := time.Now()
+	err := d.Download(context.Background(), entry, t.TempDir(), DownloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Fatalf("Download = %v, want an idle timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("idle timeout took %s", elapsed)
+	}
+}
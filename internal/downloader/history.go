@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// historyBuffer is how many records may wait for the history writer before
+// new ones are dropped
+const historyBuffer = 256
+
+// HistoryRecord is one finished download in the history log
+type HistoryRecord struct {
+	Time     time.Time `json:"time"` // When the download ended
+	FileID   string    `json:"fileId"`
+	FileName string    `json:"fileName"`
+	Folder   string    `json:"folder,omitempty"`
+	Config   string    `json:"config,omitempty"`
+	BatchID  string    `json:"batchId,omitempty"`
+	Host     string    `json:"host"`   // URL host only, paths and query tokens are not logged
+	Status   string    `json:"status"` // "completed", "unchanged", "error" or "cancelled"
+	Bytes    int64     `json:"bytes"`
+	Duration float64   `json:"durationSeconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// HistoryQuery filters and pages GetHistory results. Empty filters match
+// everything.
+type HistoryQuery struct {
+	Config string
+	Status string
+	Offset int
+	Limit  int // <= 0 returns all matches
+}
+
+// history is an append-only JSON lines log of finished downloads. Records
+// are written by a background goroutine so downloads never wait on disk.
+type history struct {
+	path    string
+	records chan HistoryRecord
+	mu      sync.Mutex // Serializes file writes and reads
+	logger  *slog.Logger
+}
+
+func newHistory(path string, logger *slog.Logger) *history {
+	h := &history{
+		path:    path,
+		records: make(chan HistoryRecord, historyBuffer),
+		logger:  logger,
+	}
+	go h.writeLoop()
+	return h
+}
+
+// add queues a record, dropping it if the writer has fallen far behind
+func (h *history) add(r HistoryRecord) {
+	select {
+	case h.records <- r:
+	default:
+		h.logger.Warn("download history backlog full, record dropped", "fileId", r.FileID)
+	}
+}
+
+func (h *history) writeLoop() {
+	for r := range h.records {
+		if err := h.append(r); err != nil {
+			h.logger.Warn("failed to write download history", "path", h.path, "error", err)
+		}
+	}
+}
+
+func (h *history) append(r HistoryRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// GetHistory returns finished downloads from the history log, newest first,
+// along with the number of records matching the filters
+func (d *Downloader) GetHistory(q HistoryQuery) ([]HistoryRecord, int, error) {
+	if d.history == nil {
+		return []HistoryRecord{}, 0, nil
+	}
+	h := d.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []HistoryRecord{}, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer f.Close()
+
+	var matches []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue // Skip a line torn by a crash
+		}
+		if (q.Config == "" || r.Config == q.Config) && (q.Status == "" || r.Status == q.Status) {
+			matches = append(matches, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	// The log is oldest first
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+
+	total := len(matches)
+	start := min(max(q.Offset, 0), total)
+	end := total
+	if q.Limit > 0 {
+		end = min(start+q.Limit, total)
+	}
+	return append([]HistoryRecord{}, matches[start:end]...), total, nil
+}
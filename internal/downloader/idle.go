@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleReader aborts a body read that receives no bytes within timeout.
+// Only time spent blocked in Read counts, so waiting on a rate limiter
+// between reads never trips it.
+type idleReader struct {
+	r       io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+// watchIdle wraps body with the downloader's idle timeout, if enabled
+func (d *Downloader) watchIdle(body io.ReadCloser) io.ReadCloser {
+	if d.idleTimeout <= 0 {
+		return body
+	}
+	ir := &idleReader{r: body, timeout: d.idleTimeout}
+	// Closing the body is the only way to unblock a pending read
+	ir.timer = time.AfterFunc(d.idleTimeout, func() {
+		ir.fired.Store(true)
+		body.Close()
+	})
+	ir.timer.Stop()
+	return ir
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.r.Read(p)
+	r.timer.Stop()
+	if r.fired.Load() {
+		return n, fmt.Errorf("no data received for %s", r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.timer.Stop()
+	return r.r.Close()
+}
+
+// errStalled is the cancellation cause of downloads aborted by watchStall
+var errStalled = errors.New("stalled")
+
+// watchStall cancels the download with an errStalled cause once the tracker
+// has made no progress for timeout. It returns when ctx is done.
+func watchStall(ctx context.Context, tracker *progressTracker, timeout time.Duration, cancel context.CancelCauseFunc) {
+	interval := time.Second
+	if timeout/2 < interval {
+		interval = timeout / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if tracker.sinceProgress() >= timeout {
+				cancel(fmt.Errorf("%w: no progress for %ds", errStalled, int(timeout.Seconds())))
+				return
+			}
+		}
+	}
+}
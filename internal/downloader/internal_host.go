@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrInternalAddress is returned when a connection to a loopback, private or
+// link-local address is refused
+var ErrInternalAddress = errors.New("connection to internal address blocked")
+
+// allowInternal lets outbound connections reach internal addresses
+var allowInternal atomic.Bool
+
+// AllowInternalAddresses controls whether downloads and URL lookups may
+// connect to loopback, private, link-local and unique-local addresses. They
+// are refused by default so a crafted config can't probe internal services.
+func AllowInternalAddresses(allow bool) {
+	allowInternal.Store(allow)
+}
+
+// IsInternalHost reports whether host (without port) is obviously internal:
+// localhost, or an IP literal in a loopback, private, link-local,
+// unique-local or unspecified range. Host names aren't resolved.
+func IsInternalHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && isInternalIP(ip)
+}
+
+// isInternalIP reports whether ip is loopback, private (including IPv6
+// unique-local), link-local or unspecified
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// rejectInternal is a net.Dialer Control function refusing internal
+// addresses. It runs after name resolution, for every connection including
+// those of redirects, so neither DNS names nor redirects get around it.
+func rejectInternal(network, address string, _ syscall.RawConn) error {
+	if allowInternal.Load() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && isInternalIP(ip) {
+		return fmt.Errorf("%w: %s", ErrInternalAddress, host)
+	}
+	return nil
+}
+
+// guardedDial dials with rejectInternal, except for the proxies in
+// proxyAddrs: a proxy from the environment on the LAN or this machine was
+// chosen by the operator, and it resolves the real target itself. Proxy
+// URLs from configs and requests are never in proxyAddrs, or they could be
+// pointed at internal services.
+func guardedDial(dialer *net.Dialer, proxyAddrs []string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	guarded := *dialer
+	guarded.Control = rejectInternal
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		for _, p := range proxyAddrs {
+			if addr == p {
+				return dialer.DialContext(ctx, network, addr)
+			}
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// envProxyAddrs returns the host:port of the proxies set in the environment
+func envProxyAddrs() []string {
+	var addrs []string
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy"} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		// Like net/http, a proxy without a scheme is taken as http
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			if u, err = url.Parse("http://" + raw); err != nil {
+				continue
+			}
+		}
+		addrs = append(addrs, proxyAddr(u))
+	}
+	return addrs
+}
+
+// proxyAddr returns the address a transport dials to reach proxy
+func proxyAddr(proxy *url.URL) string {
+	if port := proxy.Port(); port != "" {
+		return net.JoinHostPort(proxy.Hostname(), port)
+	}
+	port := "80"
+	switch proxy.Scheme {
+	case "https":
+		port = "443"
+	case "socks5":
+		port = "1080"
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
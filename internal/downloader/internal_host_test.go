@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setAllowInternal sets AllowInternalAddresses for the duration of a test
+func setAllowInternal(t testing.TB, allow bool) {
+	t.Helper()
+	prev := allowInternal.Load()
+	AllowInternalAddresses(allow)
+	t.Cleanup(func() { AllowInternalAddresses(prev) })
+}
+
+func TestExplicitProxyOnInternalAddressIsRefused(t *testing.T) {
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}))
+	defer proxy.Close()
+
+	setAllowInternal(t, false)
+	transport, err := proxyTransport(proxy.URL, defaultTransportTimeouts(), defaultTransportPool(), transportTrust{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://example.com/")
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrInternalAddress) {
+		t.Fatalf("got error %v, want ErrInternalAddress", err)
+	}
+	if proxied {
+		t.Fatal("request reached the internal proxy")
+	}
+
+	setAllowInternal(t, true)
+	resp, err = (&http.Client{Transport: transport}).Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("with internal addresses allowed: %v", err)
+	}
+	resp.Body.Close()
+	if !proxied {
+		t.Fatal("request didn't go through the proxy")
+	}
+}
+
+func TestIsInternalHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost", true},
+		{"api.localhost", true},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"169.254.169.254", true},
+		{"[::1]", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"example.com", false},
+		{"8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := IsInternalHost(tt.host); got != tt.want {
+			t.Errorf("IsInternalHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// FileMeta is the sidecar kept next to a downloaded file: where it came
+// from and what the server said about it. Refreshes use its ETag and
+// Last-Modified for conditional requests.
+type FileMeta struct {
+	URL          string    `json:"url"`
+	Config       string    `json:"config,omitempty"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+}
+
+// metaPath returns the sidecar path of a downloaded file
+func metaPath(path string) string {
+	return path + config.MetaFileSuffix
+}
+
+// readFileMeta loads the sidecar of the file at path
+func readFileMeta(path string) (*FileMeta, error) {
+	data, err := os.ReadFile(metaPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var meta FileMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// writeFileMeta stores the sidecar of the file at path
+func writeFileMeta(path string, meta FileMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(metaPath(path), data, 0644)
+}
+
+// ReadFileMeta returns the sidecar of a downloaded file. Files downloaded
+// without WriteMeta or Refresh have none, reported as os.ErrNotExist.
+func (d *Downloader) ReadFileMeta(rootDir, folder, fileName string) (*FileMeta, error) {
+	fullPath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return readFileMeta(fullPath)
+}
+
+// setConditional makes req conditional on the file described by meta still
+// being current, so an unchanged file is answered with 304 Not Modified.
+// It reports whether any validator was sent.
+func setConditional(req *http.Request, meta *FileMeta) bool {
+	if meta == nil {
+		return false
+	}
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+	return meta.ETag != "" || meta.LastModified != ""
+}
@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram bucket upper bounds
+var (
+	durationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 10800}
+	sizeBuckets     = []float64{1 << 20, 10 << 20, 100 << 20, 500 << 20, 1 << 30, 2 << 30, 5 << 30, 10 << 30, 20 << 30}
+)
+
+// metrics holds download counters exposed in the Prometheus text format
+type metrics struct {
+	started   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	cancelled atomic.Int64
+	unchanged atomic.Int64 // Refreshes the server answered with 304
+	bytes     atomic.Int64 // Bytes received over all downloads, including failed ones
+
+	duration *histogram // Seconds taken by completed downloads
+	size     *histogram // Bytes of completed downloads
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		duration: newHistogram(durationBuckets),
+		size:     newHistogram(sizeBuckets),
+	}
+}
+
+// finish counts a download that ended with the given final status
+func (m *metrics) finish(status string, elapsed time.Duration, size int64) {
+	switch status {
+	case "completed":
+		m.completed.Add(1)
+		m.duration.observe(elapsed.Seconds())
+		m.size.observe(float64(size))
+	case "cancelled":
+		m.cancelled.Add(1)
+	case "unchanged":
+		m.unchanged.Add(1)
+	default:
+		m.failed.Add(1)
+	}
+}
+
+// histogram is a fixed-bucket histogram in the Prometheus style
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // Per bucket, not cumulative; the last entry is +Inf
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.buckets) && v > h.buckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.sum), name, h.count)
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteMetrics writes download metrics in the Prometheus text exposition format
+func (d *Downloader) WriteMetrics(w io.Writer) {
+	d.mu.RLock()
+	active := len(d.cancelFns)
+	d.mu.RUnlock()
+
+	m := d.metrics
+	writeMetric(w, "multyloader_downloads_started_total", "counter", "Downloads started.", m.started.Load())
+	fmt.Fprintf(w, "# HELP multyloader_downloads_finished_total Downloads finished, by result.\n# TYPE multyloader_downloads_finished_total counter\n")
+	fmt.Fprintf(w, "multyloader_downloads_finished_total{result=\"completed\"} %d\n", m.completed.Load())
+	fmt.Fprintf(w, "multyloader_downloads_finished_total{result=\"failed\"} %d\n", m.failed.Load())
+	fmt.Fprintf(w, "multyloader_downloads_finished_total{result=\"cancelled\"} %d\n", m.cancelled.Load())
+	fmt.Fprintf(w, "multyloader_downloads_finished_total{result=\"unchanged\"} %d\n", m.unchanged.Load())
+	writeMetric(w, "multyloader_downloaded_bytes_total", "counter", "Bytes received by downloads.", m.bytes.Load())
+	writeMetric(w, "multyloader_active_downloads", "gauge", "Downloads currently running.", int64(active))
+	m.duration.write(w, "multyloader_download_duration_seconds", "Time taken by completed downloads.")
+	m.size.write(w, "multyloader_download_size_bytes", "Size of completed downloads.")
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"multy-loader/internal/config"
+)
+
+// ErrPaused is returned by Download when the download was paused
+var ErrPaused = errors.New("download paused")
+
+// ErrNotRunning is returned by Pause for downloads that aren't in progress
+var ErrNotRunning = errors.New("download is not running")
+
+// ErrNotPaused is returned by Resume for downloads that aren't paused
+var ErrNotPaused = errors.New("download is not paused")
+
+// pausedDownload is what Resume needs to restart a paused download
+type pausedDownload struct {
+	entry   config.FileEntry
+	rootDir string
+	opts    DownloadOptions
+	tmpPath string
+	partial bool // The temp file holds a contiguous prefix that can be continued
+}
+
+// Pause stops a running download but keeps its temp file, so Resume can
+// continue where it left off. Downloads split into segments have holes in
+// their temp file and start over when resumed.
+func (d *Downloader) Pause(fileID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cancel, ok := d.cancelFns[fileID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotRunning, fileID)
+	}
+	d.pausing[fileID] = true
+	cancel()
+	return nil
+}
+
+// Resume restarts a paused download in the background, requesting only the
+// bytes missing from its temp file when the server supports ranges
+func (d *Downloader) Resume(fileID string) error {
+	d.mu.Lock()
+	p, ok := d.paused[fileID]
+	if ok {
+		delete(d.paused, fileID)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotPaused, fileID)
+	}
+
+	opts := p.opts
+	opts.batch = nil // The batch it started in has already been summarized
+	opts.skipWebhook = false
+	opts.resumed = true
+	opts.continuePartial = p.partial
+	go d.Download(context.Background(), p.entry, p.rootDir, opts)
+	return nil
+}
+
+// isPausing reports whether Pause was called for a running download
+func (d *Downloader) isPausing(fileID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.pausing[fileID]
+}
@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"multy-loader/internal/config"
+)
+
+// PlanItem is what a download batch would do with one entry
+type PlanItem struct {
+	FileID   string `json:"fileId"`
+	FileName string `json:"fileName"` // After variables and AutoName are applied
+	Folder   string `json:"folder"`
+	Action   string `json:"action"` // "download", "skip" (already on disk) or "error"
+	Size     int64  `json:"size"`   // Remote size for downloads, local size for skips, -1 if unknown
+	Error    string `json:"error,omitempty"`
+}
+
+// DownloadPlan is the outcome of a dry run, in entry order
+type DownloadPlan struct {
+	Items        []PlanItem `json:"items"`
+	Download     int        `json:"download"`
+	Skip         int        `json:"skip"`
+	Errors       int        `json:"errors"`
+	TotalBytes   int64      `json:"totalBytes"`   // Sum of the known download sizes
+	UnknownSizes int        `json:"unknownSizes"` // Downloads whose size the server didn't report
+}
+
+// Plan works out what DownloadBatch would do with entries without
+// downloading anything: which files already exist and are skipped, and the
+// resolved name and size of the rest, probed like CheckURLs. No progress is
+// recorded and nothing is written to disk.
+func (d *Downloader) Plan(ctx context.Context, entries []config.FileEntry, rootDir string, opts DownloadOptions) (*DownloadPlan, error) {
+	plan := &DownloadPlan{Items: make([]PlanItem, len(entries))}
+	autoNamed := make([]bool, len(entries))
+	var probe []config.FileEntry
+	var probeIdx []int
+
+	for i, entry := range entries {
+		item := &plan.Items[i]
+		item.FileID = entry.ID
+		item.Size = -1
+
+		// Resolve names the way Download does
+		folder, err := config.ExpandVars(entry.Folder)
+		if err == nil {
+			entry.FileName, err = config.ExpandVars(entry.FileName)
+		}
+		item.Folder, item.FileName = folder, entry.FileName
+		if err != nil {
+			item.Action, item.Error = "error", err.Error()
+			continue
+		}
+		autoNamed[i] = opts.AutoName && isPlaceholderName(entry.FileName)
+		if autoNamed[i] && entry.FileName == "" {
+			item.FileName = extractFileNameFromURL(entry.URL)
+			if item.FileName == "" {
+				item.FileName = "download"
+			}
+		}
+
+		if !opts.Force && !autoNamed[i] && !opts.VerifySize && planExisting(item, rootDir, -1) {
+			continue
+		}
+		probe = append(probe, entry)
+		probeIdx = append(probeIdx, i)
+	}
+
+	checks, err := d.CheckURLs(ctx, probe, FileInfoOptions{
+		Token:    opts.Token,
+		HFToken:  opts.HFToken,
+		ProxyURL: opts.ProxyURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for j, check := range checks {
+		i := probeIdx[j]
+		item := &plan.Items[i]
+		if autoNamed[i] && check.FileName != "" {
+			item.FileName = check.FileName
+		}
+		// Auto-named files are checked once their name is known, and
+		// VerifySize checks need the remote size
+		if !opts.Force && (autoNamed[i] || opts.VerifySize) {
+			remoteSize := int64(-1)
+			if opts.VerifySize {
+				remoteSize = check.Size
+			}
+			if planExisting(item, rootDir, remoteSize) {
+				continue
+			}
+		}
+		if !check.Reachable {
+			item.Action = "error"
+			item.Error = check.Error
+			if item.Error == "" {
+				item.Error = fmt.Sprintf("bad status: %d", check.StatusCode)
+			}
+			continue
+		}
+		item.Action = "download"
+		item.Size = check.Size
+	}
+
+	for _, item := range plan.Items {
+		switch item.Action {
+		case "download":
+			plan.Download++
+			if item.Size >= 0 {
+				plan.TotalBytes += item.Size
+			} else {
+				plan.UnknownSizes++
+			}
+		case "skip":
+			plan.Skip++
+		case "error":
+			plan.Errors++
+		}
+	}
+	return plan, nil
+}
+
+// planExisting marks item as skipped when its file already exists, or as an
+// error when its path is invalid. It reports whether the item was settled.
+// With remoteSize >= 0 an existing file of another size isn't skipped.
+func planExisting(item *PlanItem, rootDir string, remoteSize int64) bool {
+	fullPath, err := config.SafeJoin(rootDir, item.Folder, item.FileName)
+	if err != nil {
+		item.Action, item.Error = "error", err.Error()
+		return true
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || (remoteSize >= 0 && info.Size() != remoteSize) {
+		return false
+	}
+	item.Action = "skip"
+	item.Size = info.Size()
+	return true
+}
@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Throttle progress updates (update max once per 200ms or 1% change)
+	progressUpdateInterval = 200 * time.Millisecond
+
+	// Speed is averaged over samples from the last few seconds
+	speedWindow    = 5 * time.Second
+	sampleInterval = 250 * time.Millisecond
+	maxSamples     = int(speedWindow/sampleInterval) + 1
+)
+
+// speedSample is a point-in-time reading of the downloaded byte count
+type speedSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// progressTracker accumulates downloaded bytes for a single file and
+// publishes throttled progress updates. It is safe for concurrent use so
+// parallel segments can report into the same counter.
+type progressTracker struct {
+	total  int64
+	update func(fn func(p *Progress)) // Applies a change to the tracked Progress and broadcasts it
+
+	received *atomic.Int64 // Optional counter of bytes received, shared across downloads
+
+	mu           sync.Mutex
+	downloaded   int64
+	lastUpdate   time.Time
+	lastPercent  float64
+	lastProgress time.Time // When downloaded last increased
+
+	// Ring buffer of recent samples for the sliding-window speed
+	samples    [maxSamples]speedSample
+	sampleHead int // Index of the oldest sample
+	sampleLen  int
+}
+
+func newProgressTracker(total int64, update func(fn func(p *Progress))) *progressTracker {
+	now := time.Now()
+	t := &progressTracker{
+		total:        total,
+		update:       update,
+		lastUpdate:   now,
+		lastProgress: now,
+	}
+	t.addSample(now)
+	return t
+}
+
+// add records n more downloaded bytes
+func (t *progressTracker) add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.downloaded += n
+
+	now := time.Now()
+	if n > 0 {
+		t.lastProgress = now
+		if t.received != nil {
+			t.received.Add(n)
+		}
+	}
+	if now.Sub(t.newestSample().at) >= sampleInterval {
+		t.addSample(now)
+	}
+
+	// Calculate progress
+	var percent float64
+	if t.total > 0 {
+		percent = float64(t.downloaded) / float64(t.total) * 100
+	}
+
+	// Throttle updates: only update if enough time passed or significant change
+	percentChanged := percent - t.lastPercent
+	shouldUpdate := now.Sub(t.lastUpdate) >= progressUpdateInterval || percentChanged >= 1.0 || percentChanged <= -1.0
+
+	if shouldUpdate {
+		downloaded := t.downloaded
+		speed := t.speed(now)
+		eta := t.eta(speed)
+		t.update(func(p *Progress) {
+			p.Downloaded = downloaded
+			p.Percent = percent
+			p.Speed = speed
+			p.ETASeconds = eta
+		})
+		t.lastUpdate = now
+		t.lastPercent = percent
+	}
+}
+
+// startAt counts n bytes already on disk from an earlier attempt. They show
+// in the progress but not in the speed.
+func (t *progressTracker) startAt(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.downloaded = n
+	t.sampleHead, t.sampleLen = 0, 0
+	t.addSample(time.Now())
+}
+
+// sinceProgress returns how long ago downloaded last increased
+func (t *progressTracker) sinceProgress() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.lastProgress)
+}
+
+// addSample pushes the current byte count, overwriting the oldest sample when full
+func (t *progressTracker) addSample(now time.Time) {
+	s := speedSample{at: now, bytes: t.downloaded}
+	if t.sampleLen < maxSamples {
+		t.samples[(t.sampleHead+t.sampleLen)%maxSamples] = s
+		t.sampleLen++
+		return
+	}
+	t.samples[t.sampleHead] = s
+	t.sampleHead = (t.sampleHead + 1) % maxSamples
+}
+
+func (t *progressTracker) newestSample() speedSample {
+	return t.samples[(t.sampleHead+t.sampleLen-1)%maxSamples]
+}
+
+// speed returns bytes per second over the sliding window ending at now
+func (t *progressTracker) speed(now time.Time) float64 {
+	// Find the oldest sample still inside the window
+	oldest := t.samples[t.sampleHead]
+	for i := 0; i < t.sampleLen; i++ {
+		s := t.samples[(t.sampleHead+i)%maxSamples]
+		if now.Sub(s.at) <= speedWindow {
+			oldest = s
+			break
+		}
+	}
+
+	// A window of a few milliseconds right after the start would report
+	// wild speeds (and skew the peak), so wait for one sample interval
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed < sampleInterval.Seconds() {
+		return 0
+	}
+	return float64(t.downloaded-oldest.bytes) / elapsed
+}
+
+// eta returns the estimated seconds remaining, or -1 if it can't be estimated
+func (t *progressTracker) eta(speed float64) int64 {
+	if t.total <= 0 || speed <= 0 {
+		return -1
+	}
+	remaining := t.total - t.downloaded
+	if remaining <= 0 {
+		return 0
+	}
+	return int64(float64(remaining)/speed + 0.5)
+}
+
+// progressReader reports every read to a tracker
+type progressReader struct {
+	r       io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.tracker.add(int64(n))
+	}
+	return n, err
+}
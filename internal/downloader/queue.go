@@ -0,0 +1,85 @@
+package downloader
+
+import "context"
+
+// queuedDownload is a download waiting for a free slot
+type queuedDownload struct {
+	fileID string
+	ready  chan struct{} // Closed once the download has been given a slot
+}
+
+// acquireSlot waits until fewer than MaxConcurrent downloads are
+// transferring. While it waits the download's progress has status "queued"
+// and its 1-based QueuePosition. Slots are handed out in arrival order.
+func (d *Downloader) acquireSlot(ctx context.Context, fileID string) error {
+	d.mu.Lock()
+	if d.maxConcurrent <= 0 || (d.active < d.maxConcurrent && len(d.queue) == 0) {
+		d.active++
+		d.mu.Unlock()
+		return nil
+	}
+	q := &queuedDownload{fileID: fileID, ready: make(chan struct{})}
+	d.queue = append(d.queue, q)
+	d.updateQueuePositions()
+	d.mu.Unlock()
+
+	select {
+	case <-q.ready:
+		return nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for i, waiting := range d.queue {
+			if waiting == q {
+				d.queue = append(d.queue[:i], d.queue[i+1:]...)
+				d.updateQueuePositions()
+				return ctx.Err()
+			}
+		}
+		// Given a slot just as it was cancelled, pass it on
+		d.releaseSlotLocked()
+		return ctx.Err()
+	}
+}
+
+// releaseSlot frees the slot of a finished download for the next one in
+// the queue
+func (d *Downloader) releaseSlot() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.releaseSlotLocked()
+}
+
+// releaseSlotLocked is releaseSlot with d.mu held
+func (d *Downloader) releaseSlotLocked() {
+	if len(d.queue) == 0 {
+		d.active--
+		return
+	}
+	// The slot goes straight to the next download, active stays the same
+	next := d.queue[0]
+	d.queue = d.queue[1:]
+	if p, ok := d.progress[next.fileID]; ok {
+		p.Status = "downloading"
+		p.QueuePosition = 0
+		d.dirty = true
+		d.broadcast(*p)
+	}
+	close(next.ready)
+	d.updateQueuePositions()
+}
+
+// updateQueuePositions publishes the position of every queued download.
+// Must be called with d.mu held.
+func (d *Downloader) updateQueuePositions() {
+	for i, q := range d.queue {
+		p, ok := d.progress[q.fileID]
+		if !ok || (p.Status == "queued" && p.QueuePosition == i+1) {
+			continue
+		}
+		p.Status = "queued"
+		p.QueuePosition = i + 1
+		d.dirty = true
+		d.broadcast(*p)
+	}
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting throughput in bytes per second.
+// A single limiter can be shared by several downloads to cap their aggregate rate.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens (bytes) added per second
+	burst  float64 // Maximum tokens the bucket can hold
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSec with the given burst size
+func NewRateLimiter(bytesPerSec int64, burst int) *RateLimiter {
+	return newRateLimiter(float64(bytesPerSec), burst)
+}
+
+// NewRequestLimiter creates a limiter for counting requests rather than
+// bytes, allowing perMinute of them with the given burst size
+func NewRequestLimiter(perMinute float64, burst int) *RateLimiter {
+	return newRateLimiter(perMinute/60, burst)
+}
+
+func newRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may be consumed or the context is done
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Reserve the tokens up front; a negative balance is paid back by waiting
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Allow takes a single token if one is available, without waiting
+func (l *RateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// rateLimitedReader throttles reads from the underlying reader
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		// Cancellation is picked up by the download loop on its next iteration
+		r.limiter.WaitN(r.ctx, n)
+	}
+	return n, err
+}
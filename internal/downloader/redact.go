@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// redactedValue replaces secrets in URLs shown to users or written to logs
+const redactedValue = "REDACTED"
+
+// sensitiveParams are query parameters that carry credentials, lowercased
+var sensitiveParams = map[string]bool{
+	"token":                true,
+	"access_token":         true,
+	"api_key":              true,
+	"apikey":               true,
+	"key":                  true,
+	"auth":                 true,
+	"password":             true,
+	"secret":               true,
+	"signature":            true,
+	"sig":                  true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+}
+
+// redactURL masks credentials in a URL: sensitive query parameters and the
+// password of any user info
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactUnparsed(rawURL)
+	}
+
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		}
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		changed := false
+		for name := range query {
+			if sensitiveParams[strings.ToLower(name)] {
+				query.Set(name, redactedValue)
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.String()
+}
+
+// redactUnparsed masks user info and the whole query of a URL that can't be parsed
+func redactUnparsed(rawURL string) string {
+	if idx := strings.IndexByte(rawURL, '?'); idx != -1 {
+		rawURL = rawURL[:idx] + "?" + redactedValue
+	}
+	if scheme := strings.Index(rawURL, "://"); scheme != -1 {
+		authority := rawURL[scheme+3:]
+		if slash := strings.IndexAny(authority, "/?"); slash != -1 {
+			authority = authority[:slash]
+		}
+		if at := strings.LastIndexByte(authority, '@'); at != -1 {
+			rawURL = rawURL[:scheme+3] + redactedValue + rawURL[scheme+3+at:]
+		}
+	}
+	return rawURL
+}
+
+// redactError returns err with the URL of any *url.Error redacted. HTTP
+// client errors embed the full request URL, which may carry the auth token.
+func redactError(err error) error {
+	var urlErr *url.Error
+	if err == nil || !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := *urlErr
+	redacted.URL = redactURL(urlErr.URL)
+	if err == error(urlErr) {
+		return &redacted
+	}
+	// Wrapped deeper, fall back to rewriting the message
+	return errors.New(strings.ReplaceAll(err.Error(), urlErr.URL, redacted.URL))
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultMaxRedirects is how many redirects a request may follow by default
+const defaultMaxRedirects = 10
+
+// redirectPolicy returns a CheckRedirect func that follows at most max
+// redirects and logs each hop. Once a redirect leaves the original host the
+// credentials sent to it are dropped, so they never reach a CDN: the
+// Authorization and Cookie headers, and query parameters carrying the
+// original URL's token.
+func redirectPolicy(max int, logger *slog.Logger) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		logger.Debug("following redirect", "hop", len(via), "from", via[len(via)-1].URL.Host, "to", req.URL.Host)
+		if req.URL.Host != via[0].URL.Host {
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+			stripCredentialParams(req.URL, via[0].URL)
+		}
+		return nil
+	}
+}
+
+// stripCredentialParams removes query parameters from target that repeat a
+// credential from original, e.g. a ?token= the server copied into the
+// redirect. Parameters the redirect target brings itself, like a CDN
+// signature, are kept.
+func stripCredentialParams(target, original *url.URL) {
+	secrets := make(map[string]string)
+	for name, values := range original.Query() {
+		if sensitiveParams[strings.ToLower(name)] && len(values) > 0 && values[0] != "" {
+			secrets[strings.ToLower(name)] = values[0]
+		}
+	}
+	if len(secrets) == 0 || target.RawQuery == "" {
+		return
+	}
+
+	query := target.Query()
+	changed := false
+	for name, values := range query {
+		if secret, ok := secrets[strings.ToLower(name)]; ok && len(values) > 0 && values[0] == secret {
+			query.Del(name)
+			changed = true
+		}
+	}
+	if changed {
+		target.RawQuery = query.Encode()
+	}
+}
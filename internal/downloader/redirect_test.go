@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"multy-loader/internal/config"
+)
+
+// seenRequest is what a test server got with a request
+type seenRequest struct {
+	auth, cookie, token string
+	query               string
+}
+
+// requestLog records the credentials every request to a test server carried
+type requestLog struct {
+	mu   sync.Mutex
+	seen []seenRequest
+}
+
+func (l *requestLog) record(r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seen = append(l.seen, seenRequest{
+		auth:   r.Header.Get("Authorization"),
+		cookie: r.Header.Get("Cookie"),
+		token:  r.URL.Query().Get("token"),
+		query:  r.URL.RawQuery,
+	})
+}
+
+func (l *requestLog) requests() []seenRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]seenRequest(nil), l.seen...)
+}
+
+// fileServer serves body and records the requests it gets
+func fileServer(t *testing.T, body string) (*httptest.Server, *requestLog) {
+	t.Helper()
+	log := &requestLog{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		w.Header().Set("Content-Length", "5")
+		if r.Method == http.MethodGet {
+			w.Write([]byte(body))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, log
+}
+
+// redirectServer redirects every request to target with the request's
+// query copied along, as some hosts do with ?token=
+func redirectServer(t *testing.T, target func() string) (*httptest.Server, *requestLog) {
+	t.Helper()
+	log := &requestLog{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		http.Redirect(w, r, target()+"?"+r.URL.RawQuery+"&X-Amz-Signature=cdn-signed", http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, log
+}
+
+// credentialedEntry is an entry sending a bearer token, a cookie and a
+// token query parameter
+func credentialedEntry(rawURL string) config.FileEntry {
+	return config.FileEntry{
+		ID:       "a",
+		URL:      rawURL + "?token=" + testSecret,
+		FileName: "file.bin",
+		Token:    testSecret,
+		Cookies:  map[string]string{"session": testSecret},
+	}
+}
+
+func TestRedirectDropsTokenAcrossHosts(t *testing.T) {
+	setAllowInternal(t, true)
+	// httptest servers differ by port, which is another host to the
+	// redirect policy
+	cdn, cdnLog := fileServer(t, "hello")
+	origin, originLog := redirectServer(t, func() string { return cdn.URL + "/file" })
+
+	d := NewDownloader(DownloaderOptions{})
+	root := t.TempDir()
+	if err := d.Download(context.Background(), credentialedEntry(origin.URL+"/f"), root, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "file.bin")); err != nil || string(data) != "hello" {
+		t.Fatalf("file contents %q, %v", data, err)
+	}
+
+	for _, r := range originLog.requests() {
+		if r.auth != "Bearer "+testSecret || r.token != testSecret || !strings.Contains(r.cookie, testSecret) {
+			t.Errorf("origin got %+v, want all credentials", r)
+		}
+	}
+	cdnRequests := cdnLog.requests()
+	if len(cdnRequests) == 0 {
+		t.Fatal("redirect was not followed")
+	}
+	for _, r := range cdnRequests {
+		if r.auth != "" || r.cookie != "" || r.token != "" {
+			t.Errorf("cdn got credentials: %+v", r)
+		}
+		if !strings.Contains(r.query, "X-Amz-Signature=cdn-signed") {
+			t.Errorf("cdn query %q lost the redirect's own signature", r.query)
+		}
+	}
+
+	p, _ := d.GetProgress("a")
+	if !strings.HasPrefix(p.FinalURL, cdn.URL+"/file") {
+		t.Errorf("FinalURL = %q, want the cdn URL", p.FinalURL)
+	}
+	if strings.Contains(p.FinalURL, "cdn-signed") {
+		t.Errorf("FinalURL = %q, want the signature redacted", p.FinalURL)
+	}
+}
+
+func TestRedirectLimit(t *testing.T) {
+	setAllowInternal(t, true)
+	var mu sync.Mutex
+	hops := 0
+	var loop *httptest.Server
+	loop = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hops++
+		mu.Unlock()
+		http.Redirect(w, r, loop.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer loop.Close()
+
+	d := NewDownloader(DownloaderOptions{MaxRedirects: 3})
+	entry := config.FileEntry{ID: "a", URL: loop.URL + "/f", FileName: "file.bin"}
+	err := d.Download(context.Background(), entry, t.TempDir(), DownloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Fatalf("Download = %v, want the redirect limit", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hops > 2*(3+1) {
+		t.Errorf("server saw %d requests, want the loop cut after 3 redirects", hops)
+	}
+}
+
+func TestCrossHostRedirectTwoHops(t *testing.T) {
+	setAllowInternal(t, true)
+	// origin -> mirror -> cdn, each on its own host
+	cdn, cdnLog := fileServer(t, "hello")
+	mirror, mirrorLog := redirectServer(t, func() string { return cdn.URL + "/file" })
+	origin, originLog := redirectServer(t, func() string { return mirror.URL + "/mirror" })
+
+	d := NewDownloader(DownloaderOptions{})
+	if err := d.Download(context.Background(), credentialedEntry(origin.URL+"/f"), t.TempDir(), DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(originLog.requests()) == 0 || len(mirrorLog.requests()) == 0 || len(cdnLog.requests()) == 0 {
+		t.Fatal("not every hop was followed")
+	}
+	for _, r := range originLog.requests() {
+		if r.auth == "" || r.cookie == "" || r.token == "" {
+			t.Errorf("origin got %+v, want all credentials", r)
+		}
+	}
+	for name, log := range map[string]*requestLog{"mirror": mirrorLog, "cdn": cdnLog} {
+		for _, r := range log.requests() {
+			if r.auth != "" || r.cookie != "" || strings.Contains(r.query, testSecret) {
+				t.Errorf("%s got credentials: %+v", name, r)
+			}
+		}
+	}
+}
+
+func TestSameHostRedirectKeepsCredentials(t *testing.T) {
+	setAllowInternal(t, true)
+	log := &requestLog{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved?"+r.URL.RawQuery, http.StatusFound)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file?"+r.URL.RawQuery, http.StatusFound)
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		w.Header().Set("Content-Length", "5")
+		if r.Method == http.MethodGet {
+			w.Write([]byte("hello"))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d := NewDownloader(DownloaderOptions{})
+	if err := d.Download(context.Background(), credentialedEntry(srv.URL+"/start"), t.TempDir(), DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	requests := log.requests()
+	if len(requests) == 0 {
+		t.Fatal("redirect was not followed")
+	}
+	for _, r := range requests {
+		if r.auth != "Bearer "+testSecret || r.token != testSecret || !strings.Contains(r.cookie, testSecret) {
+			t.Errorf("same-host redirect target got %+v, want all credentials", r)
+		}
+	}
+}
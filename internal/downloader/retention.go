@@ -0,0 +1,59 @@
+package downloader
+
+import "time"
+
+// defaultProgressTTL is how long finished downloads stay in the progress map
+const defaultProgressTTL = 10 * time.Minute
+
+// maxReapInterval bounds how late an expired entry may be removed
+const maxReapInterval = time.Minute
+
+// isFinished reports whether a status is final. Paused downloads can still
+// be resumed, so they are kept.
+func isFinished(status string) bool {
+	switch status {
+	case "completed", "unchanged", "error", "cancelled", "interrupted":
+		return true
+	}
+	return false
+}
+
+// trackFinished notes when p reached a final status, or forgets it when
+// the download is restarted. Must be called with d.mu held.
+func (d *Downloader) trackFinished(p *Progress) {
+	if !isFinished(p.Status) {
+		delete(d.finishedAt, p.FileID)
+		return
+	}
+	if _, ok := d.finishedAt[p.FileID]; !ok {
+		d.finishedAt[p.FileID] = time.Now()
+	}
+}
+
+// reapLoop periodically drops finished downloads older than the TTL
+func (d *Downloader) reapLoop() {
+	ticker := time.NewTicker(min(d.progressTTL, maxReapInterval))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		d.reapProgress(now)
+	}
+}
+
+// reapProgress removes progress entries that finished at least a TTL
+// before now. Running downloads are never removed.
+func (d *Downloader) reapProgress(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for fileID, at := range d.finishedAt {
+		if now.Sub(at) < d.progressTTL {
+			continue
+		}
+		if _, running := d.cancelFns[fileID]; running {
+			continue // Final status set, still cleaning up
+		}
+		delete(d.finishedAt, fileID)
+		delete(d.progress, fileID)
+		delete(d.batch, fileID)
+		d.dirty = true
+	}
+}
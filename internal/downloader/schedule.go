@@ -0,0 +1,240 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// scheduleCheckInterval is how often the scheduler looks for due batches.
+// Polling the wall clock keeps start times right across system sleep.
+const scheduleCheckInterval = time.Second
+
+// ErrNotScheduled is returned by CancelScheduled for unknown batches
+var ErrNotScheduled = errors.New("batch is not scheduled")
+
+// scheduledBatch is a DownloadBatch call waiting for its start time
+type scheduledBatch struct {
+	BatchID   string             `json:"batchId"`
+	StartAt   time.Time          `json:"startAt"`
+	RootDir   string             `json:"rootDir"`
+	Entries   []config.FileEntry `json:"entries"`
+	Options   DownloadOptions    `json:"options"`
+	RateLimit int64              `json:"rateLimit,omitempty"` // Bytes per second of Options.Limiter, which can't be saved
+}
+
+// Schedule runs DownloadBatch at startAt. Until then the entries report
+// Status "scheduled". With progress persistence on, scheduled batches are
+// saved and picked up again after a restart.
+func (d *Downloader) Schedule(batchID string, startAt time.Time, entries []config.FileEntry, rootDir string, opts DownloadOptions) error {
+	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return ErrShuttingDown
+	}
+	if d.batchActive(batchID) {
+		d.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrBatchRunning, batchID)
+	}
+	b := &scheduledBatch{
+		BatchID: batchID,
+		StartAt: startAt,
+		RootDir: rootDir,
+		Entries: append([]config.FileEntry(nil), entries...),
+		Options: opts,
+	}
+	if opts.Limiter != nil {
+		b.RateLimit = int64(opts.Limiter.rate)
+	}
+	d.addScheduled(b)
+	d.mu.Unlock()
+
+	d.logger.Info("batch scheduled", "batchId", batchID, "files", len(entries), "startAt", startAt)
+	d.saveSchedules()
+	return nil
+}
+
+// addScheduled registers b and marks its entries as scheduled. Must be
+// called with d.mu held.
+func (d *Downloader) addScheduled(b *scheduledBatch) {
+	d.scheduled[b.BatchID] = b
+	for _, entry := range b.Entries {
+		p := &Progress{
+			FileID:     entry.ID,
+			FileName:   entry.FileName,
+			ETASeconds: -1,
+			Status:     "scheduled",
+		}
+		d.progress[entry.ID] = p
+		d.trackFinished(p)
+		d.broadcast(*p)
+	}
+	d.dirty = true
+}
+
+// CancelScheduled drops a batch that hasn't started yet
+func (d *Downloader) CancelScheduled(batchID string) error {
+	d.mu.Lock()
+	b, ok := d.scheduled[batchID]
+	if ok {
+		delete(d.scheduled, batchID)
+		for _, entry := range b.Entries {
+			d.markCancelled(entry.ID)
+		}
+	}
+	d.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotScheduled, batchID)
+	}
+	d.saveSchedules()
+	return nil
+}
+
+// cancelScheduledEntry removes one entry from the batch it is scheduled
+// in, dropping the batch once it's empty. Must be called with d.mu held.
+func (d *Downloader) cancelScheduledEntry(fileID string) bool {
+	for batchID, b := range d.scheduled {
+		for i, entry := range b.Entries {
+			if entry.ID != fileID {
+				continue
+			}
+			b.Entries = append(b.Entries[:i], b.Entries[i+1:]...)
+			if len(b.Entries) == 0 {
+				delete(d.scheduled, batchID)
+			}
+			d.markCancelled(fileID)
+			return true
+		}
+	}
+	return false
+}
+
+// cancelAllScheduled drops every scheduled batch and returns the file IDs
+// they held. Must be called with d.mu held.
+func (d *Downloader) cancelAllScheduled() []string {
+	var cancelled []string
+	for batchID, b := range d.scheduled {
+		delete(d.scheduled, batchID)
+		for _, entry := range b.Entries {
+			d.markCancelled(entry.ID)
+			cancelled = append(cancelled, entry.ID)
+		}
+	}
+	return cancelled
+}
+
+// scheduleLoop starts scheduled batches once their time has come
+func (d *Downloader) scheduleLoop() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		d.startDue(now)
+	}
+}
+
+// startDue launches the batches scheduled at or before now
+func (d *Downloader) startDue(now time.Time) {
+	d.mu.Lock()
+	if d.closing {
+		// Leave them saved for the next run
+		d.mu.Unlock()
+		return
+	}
+	var due []*scheduledBatch
+	for batchID, b := range d.scheduled {
+		if !now.Before(b.StartAt) {
+			due = append(due, b)
+			delete(d.scheduled, batchID)
+			d.runningBatches[batchID] = true
+		}
+	}
+	d.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].StartAt.Before(due[j].StartAt) })
+	for _, b := range due {
+		opts := b.Options
+		if b.RateLimit > 0 {
+			opts.Limiter = NewRateLimiter(b.RateLimit, BufferSize)
+		}
+		d.logger.Info("starting scheduled batch", "batchId", b.BatchID, "files", len(b.Entries))
+		go d.runBatch(b.BatchID, b.Entries, b.RootDir, opts)
+	}
+	d.saveSchedules()
+}
+
+// schedulePath is where scheduled batches are saved, next to the progress
+// state. Empty when persistence is off.
+func (d *Downloader) schedulePath() string {
+	if d.statePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(d.statePath), "schedules.json")
+}
+
+// saveSchedules writes the scheduled batches to disk, removing the file
+// when there are none
+func (d *Downloader) saveSchedules() {
+	path := d.schedulePath()
+	if path == "" {
+		return
+	}
+	d.scheduleSaveMu.Lock()
+	defer d.scheduleSaveMu.Unlock()
+
+	d.mu.RLock()
+	batches := make([]*scheduledBatch, 0, len(d.scheduled))
+	for _, b := range d.scheduled {
+		batches = append(batches, b)
+	}
+	data, err := json.MarshalIndent(batches, "", "  ")
+	d.mu.RUnlock()
+
+	if err == nil && len(batches) == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = config.WriteFileAtomic(path, data, 0600) // Holds tokens
+		}
+	}
+	if err != nil {
+		d.logger.Warn("failed to save scheduled downloads", "path", path, "error", err)
+	}
+}
+
+// loadSchedules restores batches saved by a previous run. Ones that came
+// due while the server was down start on the scheduler's first check.
+func (d *Downloader) loadSchedules() error {
+	data, err := os.ReadFile(d.schedulePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var batches []*scheduledBatch
+	if err := json.Unmarshal(data, &batches); err != nil {
+		return fmt.Errorf("failed to parse scheduled downloads: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, b := range batches {
+		if b != nil && b.BatchID != "" {
+			d.addScheduled(b)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minSegmentSize is the smallest byte range worth a separate connection
+const minSegmentSize = 4 * 1024 * 1024 // 4MB
+
+// segmentCount returns how many parallel range requests to use for a response.
+// It returns 1 when the server doesn't support ranges or the file is too small.
+func segmentCount(requested int, resp *http.Response, total int64) int {
+	if requested <= 1 || total <= 0 {
+		return 1
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 1
+	}
+	if max := total / minSegmentSize; int64(requested) > max {
+		requested = int(max)
+	}
+	if requested < 1 {
+		return 1
+	}
+	return requested
+}
+
+// downloadSegments fetches the file as conns concurrent byte ranges, writing
+// each directly to its offset in file. Returns the total bytes written.
+func (d *Downloader) downloadSegments(ctx context.Context, client *http.Client, downloadURL string, headers http.Header, file *os.File, total int64, conns int, opts DownloadOptions, tracker *progressTracker) (int64, error) {
+	// A failing segment cancels the others
+	segCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	segSize := total / int64(conns)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		written  int64
+		firstErr error
+	)
+
+	for i := 0; i < conns; i++ {
+		start := int64(i) * segSize
+		end := start + segSize - 1
+		if i == conns-1 {
+			end = total - 1
+		}
+
+		wg.Add(1)
+		go func(index int, start, end int64) {
+			defer wg.Done()
+			n, err := d.downloadSegment(segCtx, client, downloadURL, headers, file, start, end, opts, tracker)
+
+			mu.Lock()
+			defer mu.Unlock()
+			written += n
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("segment %d: %w", index+1, err)
+				cancel()
+			}
+		}(i, start, end)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return written, firstErr
+	}
+	return written, nil
+}
+
+// parseContentRange parses a "bytes start-end/size" Content-Range header.
+// size is -1 when the server reports it as unknown.
+func parseContentRange(h string) (start, size int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(h), "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, sizeStr, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	startStr, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size = -1
+	if sizeStr != "*" {
+		if size, err = strconv.ParseInt(sizeStr, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, size, true
+}
+
+// downloadSegment fetches bytes [start, end] of the file into the same range of file
+func (d *Downloader) downloadSegment(ctx context.Context, client *http.Client, downloadURL string, headers http.Header, file *os.File, start, end int64, opts DownloadOptions, tracker *progressTracker) (int64, error) {
+	req, err := newDownloadRequest(ctx, downloadURL, headers)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("range request not honored: %s", resp.Status)
+	}
+	if isEncoded(resp) {
+		return 0, fmt.Errorf("range request answered with %s-encoded data", resp.Header.Get("Content-Encoding"))
+	}
+
+	var body io.Reader = d.watchIdle(resp.Body)
+	if opts.Limiter != nil {
+		body = &rateLimitedReader{ctx: ctx, r: body, limiter: opts.Limiter}
+	}
+
+	// Never write past the end of this segment, even if the server sends more
+	length := end - start + 1
+	dst := io.NewOffsetWriter(file, start)
+	n, err := copyWithProgress(ctx, dst, io.LimitReader(body, length), tracker)
+	if err != nil {
+		return n, err
+	}
+	if n != length {
+		return n, fmt.Errorf("incomplete: got %d of %d bytes", n, length)
+	}
+	return n, nil
+}
@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of a body is inspected, all http.DetectContentType looks at
+const sniffLen = 512
+
+// binaryExtensions are model and weight formats that are never HTML
+var binaryExtensions = map[string]bool{
+	".safetensors": true,
+	".ckpt":        true,
+	".pt":          true,
+	".pth":         true,
+	".bin":         true,
+	".gguf":        true,
+	".onnx":        true,
+	".pkl":         true,
+	".h5":          true,
+	".msgpack":     true,
+	".tflite":      true,
+}
+
+// expectsBinary reports whether fileName is a model or archive, so an HTML
+// response for it is an error page rather than the file
+func expectsBinary(fileName string) bool {
+	return binaryExtensions[strings.ToLower(filepath.Ext(fileName))] || IsArchive(fileName)
+}
+
+// peekedBody reads a response body through the buffer its start was sniffed into
+type peekedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// isHTMLResponse reports whether resp carries an HTML page, going by its
+// Content-Type or, since servers often send a generic or wrong one, by its
+// first bytes. The sniffed bytes are kept in resp.Body.
+func isHTMLResponse(resp *http.Response) bool {
+	if isHTMLType(resp.Header.Get("Content-Type")) {
+		return true
+	}
+	br := bufio.NewReaderSize(resp.Body, sniffLen)
+	resp.Body = peekedBody{br, resp.Body}
+	head, _ := br.Peek(sniffLen) // Read errors surface when the body is copied
+	return isHTMLType(http.DetectContentType(head))
+}
+
+func isHTMLType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
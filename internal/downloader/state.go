@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// stateSaveInterval is how often a changed progress map is written to disk
+const stateSaveInterval = 2 * time.Second
+
+// loadState restores the progress map saved by a previous run.
+// Downloads that were still running or paused are marked as interrupted,
+// paused ones can only be resumed within the process that paused them.
+func (d *Downloader) loadState() error {
+	data, err := os.ReadFile(d.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read progress state: %w", err)
+	}
+
+	var saved map[string]*Progress
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse progress state: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for fileID, p := range saved {
+		if p == nil {
+			continue
+		}
+		if p.Status == "downloading" || p.Status == "queued" || p.Status == "paused" {
+			p.Status = "interrupted"
+			p.QueuePosition = 0
+			p.Speed = 0
+			p.ETASeconds = -1
+		}
+		d.progress[fileID] = p
+		d.trackFinished(p)
+	}
+	return nil
+}
+
+// saveState writes a snapshot of the progress map to disk
+func (d *Downloader) saveState() error {
+	d.mu.Lock()
+	data, err := json.MarshalIndent(d.progress, "", "  ")
+	d.dirty = false
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal progress state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.statePath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := config.WriteFileAtomic(d.statePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write progress state: %w", err)
+	}
+	return nil
+}
+
+// persistLoop periodically saves the progress map when it has changed
+func (d *Downloader) persistLoop() {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.mu.RLock()
+		dirty := d.dirty
+		d.mu.RUnlock()
+		if dirty {
+			if err := d.saveState(); err != nil {
+				d.logger.Warn("failed to save download progress", "path", d.statePath, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package downloader
+
+import "time"
+
+// Stats summarizes bandwidth use since the downloader was created
+type Stats struct {
+	Active     int                `json:"active"`
+	Speed      float64            `json:"speed"`      // Combined bytes per second of active downloads
+	PeakSpeed  float64            `json:"peakSpeed"`  // Highest combined speed seen
+	Downloads  map[string]float64 `json:"downloads"`  // Bytes per second of each active download, by file ID
+	Downloaded int64              `json:"downloaded"` // Bytes received, including downloads that failed
+	Uptime     float64            `json:"uptimeSeconds"`
+}
+
+// GetStats returns current and peak throughput and the bytes received so far.
+// Speeds are the sliding-window speeds reported in each download's progress.
+func (d *Downloader) GetStats() Stats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	s := Stats{
+		Downloads:  make(map[string]float64),
+		PeakSpeed:  d.peakSpeed,
+		Downloaded: d.metrics.bytes.Load(),
+		Uptime:     time.Since(d.startedAt).Seconds(),
+	}
+	for fileID := range d.cancelFns {
+		if p, ok := d.progress[fileID]; ok && p.Status == "downloading" {
+			s.Active++
+			s.Speed += p.Speed
+			s.Downloads[fileID] = p.Speed
+		}
+	}
+	return s
+}
+
+// trackPeakSpeed updates the peak combined speed. Must be called with d.mu held.
+func (d *Downloader) trackPeakSpeed() {
+	var speed float64
+	for fileID := range d.cancelFns {
+		if p, ok := d.progress[fileID]; ok && p.Status == "downloading" {
+			speed += p.Speed
+		}
+	}
+	if speed > d.peakSpeed {
+		d.peakSpeed = speed
+	}
+}
@@ -0,0 +1,229 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default transport timeouts. None of them limit how long a body may take to
+// stream, so slow but steady downloads of large files are never cut off.
+const (
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 60 * time.Second
+	defaultIdleTimeout           = 60 * time.Second
+)
+
+// Default connection pool settings. Go keeps only 2 idle connections per
+// host, so batches against one CDN kept reconnecting; segmented downloads
+// alone open several connections to the same host.
+const (
+	defaultMaxIdleConnsPerHost = 16
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// transportTimeouts are the connection-level timeouts applied to a transport
+type transportTimeouts struct {
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+}
+
+// defaultTransportTimeouts returns the timeouts used when none are configured
+func defaultTransportTimeouts() transportTimeouts {
+	return transportTimeouts{
+		dial:           defaultDialTimeout,
+		tlsHandshake:   defaultTLSHandshakeTimeout,
+		responseHeader: defaultResponseHeaderTimeout,
+	}
+}
+
+// transportPool are the connection pool settings applied to a transport
+type transportPool struct {
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int // Zero is unlimited
+	idleConnTimeout     time.Duration
+}
+
+// defaultTransportPool returns the pool settings used when none are configured
+func defaultTransportPool() transportPool {
+	return transportPool{
+		maxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		idleConnTimeout:     defaultIdleConnTimeout,
+	}
+}
+
+// transportTrust decides which TLS certificates a transport accepts
+type transportTrust struct {
+	rootCAs  *x509.CertPool // Nil uses the system roots
+	insecure bool           // Accept any certificate
+}
+
+// LoadCABundle returns the system roots plus the PEM certificates in path,
+// for servers signed by a private certificate authority
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+type transportKey struct {
+	proxyURL string
+	timeouts transportTimeouts
+	pool     transportPool
+	trust    transportTrust
+}
+
+// maxProxyTransports bounds the cached transports of explicit proxies. Any
+// API request can name a proxy, so the cache can't keep one per value.
+const maxProxyTransports = 32
+
+// cachedTransport is a shared transport and when it was last handed out
+type cachedTransport struct {
+	t        *http.Transport
+	lastUsed uint64 // Value of transportClock
+}
+
+var (
+	transportsMu   sync.Mutex
+	transports     = make(map[transportKey]*cachedTransport) // Shared transports so connections are reused
+	transportClock uint64                                    // Counts proxyTransport calls, guarded by transportsMu
+)
+
+// proxyTransport returns the shared transport for proxyURL, timeouts, pool
+// and certificate settings, creating it on first use. An empty proxyURL
+// honors HTTP_PROXY/HTTPS_PROXY/NO_PROXY. Transports of explicit proxies
+// are cached up to maxProxyTransports, evicting the least recently used.
+func proxyTransport(proxyURL string, timeouts transportTimeouts, pool transportPool, trust transportTrust) (*http.Transport, error) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	transportClock++
+	key := transportKey{proxyURL: proxyURL, timeouts: timeouts, pool: pool, trust: trust}
+	if c, ok := transports[key]; ok {
+		c.lastUsed = transportClock
+		return c.t, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	var proxyAddrs []string // Exempt from the internal address check
+	if proxyURL == "" {
+		t.Proxy = http.ProxyFromEnvironment
+		proxyAddrs = envProxyAddrs()
+	} else {
+		// Explicit proxies come from configs and requests, so they are
+		// dialed like any other host
+		parsed, err := ParseProxyURL(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(parsed)
+	}
+	t.DialContext = guardedDial(&net.Dialer{
+		Timeout:   timeouts.dial,
+		KeepAlive: 30 * time.Second,
+	}, proxyAddrs)
+	t.TLSHandshakeTimeout = timeouts.tlsHandshake
+	t.ResponseHeaderTimeout = timeouts.responseHeader
+	t.MaxIdleConnsPerHost = pool.maxIdleConnsPerHost
+	t.MaxIdleConns = max(t.MaxIdleConns, pool.maxIdleConnsPerHost)
+	t.MaxConnsPerHost = pool.maxConnsPerHost
+	t.IdleConnTimeout = pool.idleConnTimeout
+
+	// HTTP/2 is negotiated over TLS, which a custom TLSClientConfig would
+	// otherwise switch off
+	t.ForceAttemptHTTP2 = true
+	if trust.rootCAs != nil || trust.insecure {
+		t.TLSClientConfig = &tls.Config{
+			RootCAs:            trust.rootCAs,
+			InsecureSkipVerify: trust.insecure,
+		}
+	}
+
+	if proxyURL != "" {
+		evictProxyTransports(maxProxyTransports - 1)
+	}
+	transports[key] = &cachedTransport{t: t, lastUsed: transportClock}
+	return t, nil
+}
+
+// evictProxyTransports drops the least recently used explicit proxy
+// transports until at most keep are left, closing their idle connections.
+// Requests still running on them are unaffected. Must be called with
+// transportsMu held.
+func evictProxyTransports(keep int) {
+	for {
+		var oldest transportKey
+		var oldestUse uint64
+		n := 0
+		for key, c := range transports {
+			if key.proxyURL == "" {
+				continue
+			}
+			n++
+			if oldestUse == 0 || c.lastUsed < oldestUse {
+				oldest, oldestUse = key, c.lastUsed
+			}
+		}
+		if n <= keep {
+			return
+		}
+		transports[oldest].t.CloseIdleConnections()
+		delete(transports, oldest)
+	}
+}
+
+// ParseProxyURL validates an explicit proxy URL
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", redactError(err))
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL: unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: missing host")
+	}
+	return parsed, nil
+}
+
+// withCookieJar returns a copy of client with an empty cookie jar, so cookies
+// set by a response are sent again on redirects and follow-up requests
+func withCookieJar(client *http.Client) *http.Client {
+	c := *client
+	c.Jar, _ = cookiejar.New(nil) // Never fails with nil options
+	return &c
+}
+
+// clientFor returns an HTTP client using the given proxy, or the environment
+// proxy settings when proxyURL is empty
+func (d *Downloader) clientFor(proxyURL string) (*http.Client, error) {
+	if proxyURL == "" {
+		return d.client, nil
+	}
+	t, err := proxyTransport(proxyURL, d.timeouts, d.pool, d.trust)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, CheckRedirect: redirectPolicy(d.maxRedirects, d.logger)}, nil
+}
@@ -0,0 +1,228 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"multy-loader/internal/config"
+)
+
+// trashTimeFormat prefixes trashed file names, so two deletions of the same
+// file don't collide and the deletion time can be read back
+const trashTimeFormat = "20060102-150405.000"
+
+// ErrNotInTrash is returned when restoring or purging an unknown trash item
+var ErrNotInTrash = errors.New("not in trash")
+
+// TrashItem is a deleted file waiting in a root directory's trash
+type TrashItem struct {
+	ID        string    `json:"id"`     // Path inside the trash folder, used to restore or purge it
+	Folder    string    `json:"folder"` // Where the file was, relative to the root
+	FileName  string    `json:"fileName"`
+	DeletedAt time.Time `json:"deletedAt"`
+	Size      int64     `json:"size"`
+}
+
+// trashRoot returns the resolved root directory and its trash folder
+func trashRoot(rootDir string) (string, string, error) {
+	root, err := config.ResolveRoot(rootDir)
+	if err != nil {
+		return "", "", err
+	}
+	return root, filepath.Join(root, config.TrashFolder), nil
+}
+
+// TrashFile moves a file into the trash folder under its root instead of
+// deleting it, keeping its folder so it can be restored. The sidecar goes
+// with it. Like DeleteFile, a missing file isn't an error.
+func (d *Downloader) TrashFile(rootDir, folder, fileName string) error {
+	fullPath, err := config.EntryPath(rootDir, folder, fileName)
+	if err != nil {
+		return err
+	}
+	root, trash, err := trashRoot(rootDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		return err
+	}
+	// Deleting from the trash itself is for good
+	if rel == config.TrashFolder || strings.HasPrefix(rel, config.TrashFolder+string(filepath.Separator)) {
+		return d.DeleteFile(rootDir, folder, fileName)
+	}
+
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		return nil // Already deleted
+	}
+	dest := filepath.Join(trash, filepath.Dir(rel), time.Now().Format(trashTimeFormat)+"_"+filepath.Base(rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create trash folder: %w", err)
+	}
+	if err := os.Rename(fullPath, dest); err != nil {
+		return fmt.Errorf("failed to move file to trash: %w", err)
+	}
+	os.Rename(metaPath(fullPath), metaPath(dest))
+	return nil
+}
+
+// ListTrash returns the files in a root directory's trash, newest first
+func (d *Downloader) ListTrash(rootDir string) ([]TrashItem, error) {
+	_, trash, err := trashRoot(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []TrashItem{}
+	err = filepath.WalkDir(trash, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == trash {
+				return fs.SkipAll // Nothing deleted yet
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), config.MetaFileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(trash, p)
+		if err != nil {
+			return err
+		}
+		item, ok := parseTrashItem(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			item.Size = info.Size()
+		}
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// parseTrashItem reads the original location and deletion time from a
+// trash ID, "<folder>/<time>_<file name>"
+func parseTrashItem(id string) (TrashItem, bool) {
+	dir, base := path.Split(id)
+	stamp, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return TrashItem{}, false
+	}
+	deletedAt, err := time.ParseInLocation(trashTimeFormat, stamp, time.Local)
+	if err != nil {
+		return TrashItem{}, false
+	}
+	return TrashItem{
+		ID:        id,
+		Folder:    strings.TrimSuffix(dir, "/"),
+		FileName:  name,
+		DeletedAt: deletedAt,
+	}, true
+}
+
+// trashItemPath validates a trash ID and returns the item and its path
+func trashItemPath(rootDir, trash, id string) (TrashItem, string, error) {
+	item, ok := parseTrashItem(filepath.ToSlash(id))
+	if !ok {
+		return TrashItem{}, "", fmt.Errorf("%w: %s", ErrNotInTrash, id)
+	}
+	p, err := config.SafeJoin(rootDir, config.TrashFolder, filepath.FromSlash(item.ID))
+	if err != nil {
+		return TrashItem{}, "", err
+	}
+	if !strings.HasPrefix(p, trash+string(filepath.Separator)) {
+		return TrashItem{}, "", fmt.Errorf("%w: %s", config.ErrPathEscapesRoot, id)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return TrashItem{}, "", fmt.Errorf("%w: %s", ErrNotInTrash, id)
+		}
+		return TrashItem{}, "", err
+	}
+	item.Size = info.Size()
+	return item, p, nil
+}
+
+// RestoreTrash moves a trashed file back to where it was deleted from. An
+// existing file there is only replaced when force is set.
+func (d *Downloader) RestoreTrash(rootDir, id string, force bool) (TrashItem, error) {
+	_, trash, err := trashRoot(rootDir)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	item, src, err := trashItemPath(rootDir, trash, id)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	dest, err := config.SafeJoin(rootDir, filepath.FromSlash(item.Folder), item.FileName)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if _, err := os.Stat(dest); err == nil && !force {
+		return TrashItem{}, fmt.Errorf("destination %s: %w", path.Join(item.Folder, item.FileName), os.ErrExist)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return TrashItem{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return TrashItem{}, fmt.Errorf("failed to restore file: %w", err)
+	}
+	if err := os.Rename(metaPath(src), metaPath(dest)); err != nil {
+		os.Remove(metaPath(dest)) // Not this file's
+	}
+	removeEmptyParents(trash, filepath.Dir(src))
+	return item, nil
+}
+
+// PurgeTrash permanently deletes the given trash items, or everything in
+// the trash when ids is empty, and returns how many files were removed
+func (d *Downloader) PurgeTrash(rootDir string, ids []string) (int, error) {
+	_, trash, err := trashRoot(rootDir)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		items, err := d.ListTrash(rootDir)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.RemoveAll(trash); err != nil {
+			return 0, fmt.Errorf("failed to empty trash: %w", err)
+		}
+		return len(items), nil
+	}
+
+	purged := 0
+	for _, id := range ids {
+		_, p, err := trashItemPath(rootDir, trash, id)
+		if err != nil {
+			return purged, err
+		}
+		if err := os.Remove(p); err != nil {
+			return purged, fmt.Errorf("failed to purge %s: %w", id, err)
+		}
+		os.Remove(metaPath(p))
+		removeEmptyParents(trash, filepath.Dir(p))
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"multy-loader/internal/config"
+)
+
+// urlCheckWorkers bounds how many URLs are probed at once
+const urlCheckWorkers = 8
+
+// URLCheck reports whether a file's URL can be downloaded, without downloading it
+type URLCheck struct {
+	FileID     string `json:"fileId"`
+	Reachable  bool   `json:"reachable"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Size       int64  `json:"size"` // -1 if unknown
+	FileName   string `json:"fileName,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CheckURLs probes every entry's URL with a HEAD request, falling back to a
+// ranged GET for servers that reject HEAD. Results are in entry order.
+func (d *Downloader) CheckURLs(ctx context.Context, entries []config.FileEntry, opts FileInfoOptions) ([]URLCheck, error) {
+	client, err := d.infoClient(opts.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]URLCheck, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(urlCheckWorkers, len(entries)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = d.checkURL(client, entries[i], opts)
+			}
+		}()
+	}
+
+feed:
+	for i := range entries {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (d *Downloader) checkURL(client *http.Client, entry config.FileEntry, opts FileInfoOptions) URLCheck {
+	result := URLCheck{FileID: entry.ID, Size: -1}
+	if entry.URL == "" {
+		result.Error = "no URL"
+		return result
+	}
+
+	requestURL, headers := entryRequest(entry, opts.Token, opts.HFToken, d.userAgent)
+
+	probe, err := probeURL(client, "HEAD", requestURL, headers)
+	if err != nil || probe.statusCode >= 400 || probe.size < 0 {
+		// Some hosts reject HEAD or omit the size, a ranged GET is more reliable
+		if getProbe, getErr := probeURL(client, "GET", requestURL, headers); getErr == nil {
+			probe, err = getProbe, nil
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.StatusCode = probe.statusCode
+	result.Reachable = probe.statusCode >= 200 && probe.statusCode < 300
+	if !result.Reachable {
+		return result // Error pages say nothing about the file
+	}
+	result.Size = probe.size
+	if probe.fileName != "" && !looksLikeID(probe.fileName) {
+		result.FileName = probe.fileName
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// webhookTimeout bounds a single webhook delivery
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is POSTed to the webhook when a download completes or fails.
+// Text and Content carry the same summary line so Slack and Discord
+// incoming webhooks can display it without an adapter.
+type webhookPayload struct {
+	Text     string  `json:"text"`
+	Content  string  `json:"content"`
+	FileID   string  `json:"fileId"`
+	FileName string  `json:"fileName"`
+	Config   string  `json:"config,omitempty"`
+	Status   string  `json:"status"` // "completed" or "error"
+	Size     int64   `json:"size"`   // Bytes downloaded
+	Duration float64 `json:"durationSeconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func (p *webhookPayload) summary() string {
+	name := p.FileName
+	if p.Config != "" {
+		name = p.Config + "/" + name
+	}
+	if p.Status == "completed" {
+		return fmt.Sprintf("Downloaded %s (%s in %.0fs)", name, formatBytes(p.Size), p.Duration)
+	}
+	return fmt.Sprintf("Download of %s failed: %s", name, p.Error)
+}
+
+// notifyWebhook reports a finished download to the webhook
+func (d *Downloader) notifyWebhook(webhookURL string, payload webhookPayload) {
+	payload.Text = payload.summary()
+	payload.Content = payload.Text
+	d.postWebhook(webhookURL, payload, "fileId", payload.FileID)
+}
+
+// postWebhook delivers payload as JSON in the background. Failures are only
+// logged so a slow or broken webhook never holds up downloads.
+func (d *Downloader) postWebhook(webhookURL string, payload any, logAttrs ...any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	// The webhook URL itself is usually a secret, so only its host is logged
+	logger := d.logger.With(append(logAttrs, "webhookHost", urlHost(webhookURL))...)
+	go func() {
+		resp, err := d.hookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err // Drop the URL from the message
+			}
+			logger.Warn("webhook delivery failed", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Warn("webhook delivery failed", "status", resp.StatusCode)
+			return
+		}
+		logger.Debug("webhook delivered", "status", resp.StatusCode)
+	}()
+}
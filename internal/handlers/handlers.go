@@ -1,15 +1,22 @@
 package handlers
 
 import (
-	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"multy-loader/internal/config"
 	"multy-loader/internal/downloader"
+	"multy-loader/internal/websocket"
 )
 
 // CheckCivitaiURL checks if URL is from civitai.com
@@ -19,19 +26,117 @@ func (h *Handler) CheckCivitaiURL(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, map[string]bool{"isCivitai": isCivitai})
 }
 
-// GetFileInfo fetches filename from URL headers
+// FileInfoRequest for looking up a remote file before adding it
+type FileInfoRequest struct {
+	URL        string            `json:"url"`
+	Token      string            `json:"token"`
+	HFToken    string            `json:"hfToken"`
+	Headers    map[string]string `json:"headers"`
+	Cookies    map[string]string `json:"cookies"`
+	Username   string            `json:"username"`
+	Password   string            `json:"password"`
+	ProxyURL   string            `json:"proxyUrl"`
+	EntryToken string            `json:"entryToken"`
+}
+
+// fileInfoSecretParams are the GetFileInfo fields only accepted in a POST
+// body, since query strings end up in access logs and browser history
+var fileInfoSecretParams = []string{"token", "hfToken", "headers", "cookies", "username", "password", "entryToken"}
+
+// GetFileInfo fetches filename from URL headers. Credentials come in a POST
+// JSON body; a GET takes only url and proxyUrl, for public files.
 func (h *Handler) GetFileInfo(w http.ResponseWriter, r *http.Request) {
-	targetURL := r.URL.Query().Get("url")
+	var req FileInfoRequest
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+	case http.MethodGet:
+		query := r.URL.Query()
+		for _, name := range fileInfoSecretParams {
+			if query.Has(name) {
+				errorResponse(w, http.StatusBadRequest, name+" must be sent in a POST body, not the query string")
+				return
+			}
+		}
+		req.URL = query.Get("url")
+		req.ProxyURL = query.Get("proxyUrl")
+	default:
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	targetURL := req.URL
 	if targetURL == "" {
 		errorResponse(w, http.StatusBadRequest, "url required")
 		return
 	}
-	token := r.URL.Query().Get("token")
+	if !h.allowOutbound(w, r, targetURL) {
+		return
+	}
 
-	fileName, fileSize := downloader.GetFileInfoFromURL(targetURL, token)
+	opts := downloader.FileInfoOptions{
+		Token:    req.Token,
+		HFToken:  req.HFToken,
+		Headers:  req.Headers,
+		Cookies:  req.Cookies,
+		Username: req.Username,
+		Password: req.Password,
+		ProxyURL: req.ProxyURL,
+
+		EntryToken: req.EntryToken,
+	}
+
+	// Model pages aren't downloadable themselves, so answer with their files
+	if _, _, ok := downloader.ParseCivitaiModelURL(targetURL); ok {
+		h.civitaiFileInfo(w, r, targetURL, opts)
+		return
+	}
+
+	info, err := h.downloader.GetFileInfoFromURL(targetURL, opts)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Dead links still get a 200 so the page can tell them apart from bad requests
+	resp := map[string]interface{}{
+		"fileName":  info.FileName,
+		"fileSize":  info.Size,
+		"status":    info.StatusCode,
+		"reachable": info.Reachable,
+	}
+	if info.Error != "" {
+		resp["error"] = info.Error
+	}
+	jsonResponse(w, resp)
+}
+
+// civitaiFileInfo responds to GetFileInfo for a Civitai model page with the
+// first file's details and its downloadUrl, plus every file under "files"
+// so the user can pick another version or file
+func (h *Handler) civitaiFileInfo(w http.ResponseWriter, r *http.Request, pageURL string, opts downloader.FileInfoOptions) {
+	files, err := h.downloader.ResolveCivitaiModelURL(r.Context(), pageURL, opts)
+	if err != nil {
+		jsonResponse(w, map[string]interface{}{
+			"fileName":  "",
+			"fileSize":  -1,
+			"status":    0,
+			"reachable": false,
+			"error":     err.Error(),
+		})
+		return
+	}
+	first := files[0]
 	jsonResponse(w, map[string]interface{}{
-		"fileName": fileName,
-		"fileSize": fileSize,
+		"fileName":    first.FileName,
+		"fileSize":    first.Size,
+		"status":      http.StatusOK,
+		"reachable":   true,
+		"downloadUrl": first.DownloadURL,
+		"sha256":      first.SHA256,
+		"files":       files,
 	})
 }
 
@@ -39,13 +144,20 @@ func (h *Handler) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 type Handler struct {
 	configMgr  *config.Manager
 	downloader *downloader.Downloader
+	logger     *slog.Logger
+	buildInfo  BuildInfo
+	outbound   *outboundGuard // Nil leaves outbound endpoints unlimited
 }
 
-// NewHandler creates a new handler
-func NewHandler(configMgr *config.Manager, dl *downloader.Downloader) *Handler {
+// NewHandler creates a new handler. A nil logger uses slog.Default().
+func NewHandler(configMgr *config.Manager, dl *downloader.Downloader, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return &Handler{
 		configMgr:  configMgr,
 		downloader: dl,
+		logger:     logger,
 	}
 }
 
@@ -61,6 +173,48 @@ func errorResponse(w http.ResponseWriter, status int, message string) {
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+// queryTokenPaths are the endpoints that accept the API token as a token
+// query parameter, for EventSource and WebSocket clients that can't set
+// headers. Elsewhere ?token= is a Civitai token, and URLs end up in logs
+// and browser history.
+var queryTokenPaths = map[string]bool{
+	"/api/progress/stream": true,
+	"/api/progress/ws":     true,
+}
+
+// RequireToken rejects /api/ and /metrics requests that don't carry token
+// as an "Authorization: Bearer" header, or as a token query parameter on
+// queryTokenPaths. The page itself and /api/version stay public.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protected := strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/metrics"
+		if !protected || r.URL.Path == "/api/version" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok && queryTokenPaths[r.URL.Path] {
+			given = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(given)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="multy-loader"`)
+			errorResponse(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errorStatus maps rejected client-supplied paths and configs to 400 and other errors to fallback
+func errorStatus(err error, fallback int) int {
+	if errors.Is(err, config.ErrPathEscapesRoot) || errors.Is(err, config.ErrInvalidConfig) || errors.Is(err, config.ErrInvalidRoot) ||
+		errors.Is(err, config.ErrUndefinedVariable) {
+		return http.StatusBadRequest
+	}
+	return fallback
+}
+
 // ListConfigs returns all config names
 func (h *Handler) ListConfigs(w http.ResponseWriter, r *http.Request) {
 	configs, err := h.configMgr.ListConfigs()
@@ -81,9 +235,11 @@ func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
 
 	cfg, err := h.configMgr.LoadConfig(name)
 	if err != nil {
+		h.logger.Warn("config load failed", "name", name, "error", err)
 		errorResponse(w, http.StatusNotFound, err.Error())
 		return
 	}
+	h.logger.Debug("config loaded", "name", name, "files", len(cfg.Files))
 	jsonResponse(w, cfg)
 }
 
@@ -95,11 +251,42 @@ func (h *Handler) SaveConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	remapped, err := assignConfigIDs(r, &cfg)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err := h.configMgr.SaveConfig(&cfg); err != nil {
-		errorResponse(w, http.StatusInternalServerError, err.Error())
+		h.logger.Warn("config save failed", "name", cfg.Name, "error", err)
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
 		return
 	}
-	jsonResponse(w, map[string]string{"status": "ok"})
+	h.logger.Info("config saved", "name", cfg.Name, "files", len(cfg.Files), "remappedIds", len(remapped))
+
+	resp := map[string]interface{}{"status": "ok"}
+	if len(remapped) > 0 {
+		resp["remappedIds"] = remapped
+	}
+	// Saved anyway, but e.g. colliding file names are worth knowing before downloading
+	if warnings := cfg.Warnings(); len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	jsonResponse(w, resp)
+}
+
+// assignConfigIDs fills in missing entry IDs. The duplicateIds query parameter
+// picks how repeated IDs are handled: "reject" (default) fails the save,
+// "fix" gives the later entries new IDs.
+func assignConfigIDs(r *http.Request, cfg *config.Config) ([]config.IDChange, error) {
+	switch mode := r.URL.Query().Get("duplicateIds"); mode {
+	case "", "reject":
+		return cfg.AssignIDs(false), nil
+	case "fix":
+		return cfg.AssignIDs(true), nil
+	default:
+		return nil, fmt.Errorf("invalid duplicateIds mode %q, expected reject or fix", mode)
+	}
 }
 
 // DeleteConfig deletes a config
@@ -114,29 +301,134 @@ func (h *Handler) DeleteConfig(w http.ResponseWriter, r *http.Request) {
 		errorResponse(w, http.StatusNotFound, err.Error())
 		return
 	}
+	h.logger.Info("config deleted", "name", name)
 	jsonResponse(w, map[string]string{"status": "ok"})
 }
 
-// GetFolders returns folders in a directory
+// GetFolders returns folders in a directory as a flat list, or as a tree
+// with tree=true. depth limits how many levels are listed, folder starts
+// below a subfolder and exclude replaces the default name patterns to skip
+// (comma-separated, empty to list everything). follow=true lists symlinked
+// folders too.
 func (h *Handler) GetFolders(w http.ResponseWriter, r *http.Request) {
-	rootDir := r.URL.Query().Get("root")
+	q := r.URL.Query()
+	rootDir := q.Get("root")
 	if rootDir == "" {
 		errorResponse(w, http.StatusBadRequest, "root directory required")
 		return
 	}
 
-	folders, err := config.GetFoldersInRoot(rootDir)
+	depth, err := queryInt(q.Get("depth"), 0)
 	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, err.Error())
+		errorResponse(w, http.StatusBadRequest, "invalid depth")
+		return
+	}
+	opts := config.FolderScanOptions{
+		MaxDepth:       depth,
+		Folder:         q.Get("folder"),
+		FollowSymlinks: q.Get("follow") == "true",
+	}
+	if q.Has("exclude") {
+		opts.Exclude = []string{}
+		for _, pattern := range strings.Split(q.Get("exclude"), ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				opts.Exclude = append(opts.Exclude, pattern)
+			}
+		}
+	}
+
+	if q.Get("tree") == "true" {
+		tree, err := config.GetFolderTree(rootDir, opts)
+		if err != nil {
+			errorResponse(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		jsonResponse(w, tree)
+		return
+	}
+
+	folders, err := config.GetFoldersInRoot(rootDir, opts)
+	if err != nil {
+		errorResponse(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+	jsonResponse(w, folders)
+}
+
+// FolderRequest for creating or removing a folder under the root directory
+type FolderRequest struct {
+	RootDir   string `json:"rootDir"`
+	Folder    string `json:"folder"`    // Relative to root directory
+	Recursive bool   `json:"recursive"` // Remove non-empty folders with their contents
+}
+
+// CreateFolder creates a folder and returns the updated folder list
+func (h *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	var req FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.RootDir == "" || req.Folder == "" {
+		errorResponse(w, http.StatusBadRequest, "root directory and folder required")
+		return
+	}
+
+	if err := config.CreateFolder(req.RootDir, req.Folder); err != nil {
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+	h.folderList(w, req.RootDir)
+}
+
+// RemoveFolder removes a folder and returns the updated folder list
+func (h *Handler) RemoveFolder(w http.ResponseWriter, r *http.Request) {
+	var req FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.RootDir == "" || req.Folder == "" {
+		errorResponse(w, http.StatusBadRequest, "root directory and folder required")
+		return
+	}
+
+	if err := config.RemoveFolder(req.RootDir, req.Folder, req.Recursive); err != nil {
+		errorResponse(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+	h.folderList(w, req.RootDir)
+}
+
+func (h *Handler) folderList(w http.ResponseWriter, rootDir string) {
+	folders, err := config.GetFoldersInRoot(rootDir, config.FolderScanOptions{})
+	if err != nil {
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
 		return
 	}
 	jsonResponse(w, folders)
 }
 
+// FoldersHandler routes /api/folders based on method
+func (h *Handler) FoldersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetFolders(w, r)
+	case http.MethodPost:
+		h.CreateFolder(w, r)
+	case http.MethodDelete:
+		h.RemoveFolder(w, r)
+	default:
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
 // FileStatusRequest for checking file status
 type FileStatusRequest struct {
 	RootDir string             `json:"rootDir"`
 	Files   []config.FileEntry `json:"files"`
+
+	config.EntryDefaults // Of the config the files come from
 }
 
 // FileStatusResponse contains file statuses
@@ -144,6 +436,9 @@ type FileStatusResponse struct {
 	Statuses map[string]downloader.FileStatus `json:"statuses"`
 }
 
+// fileStatusWorkers bounds the concurrent stat calls of one CheckFileStatus request
+const fileStatusWorkers = 16
+
 // CheckFileStatus checks status of files
 func (h *Handler) CheckFileStatus(w http.ResponseWriter, r *http.Request) {
 	var req FileStatusRequest
@@ -151,20 +446,69 @@ func (h *Handler) CheckFileStatus(w http.ResponseWriter, r *http.Request) {
 		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
+	req.Files = req.ResolveAll(req.Files)
 
-	statuses := make(map[string]downloader.FileStatus)
+	// Stat in parallel, on network filesystems each call can take a while
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	statuses := make(map[string]downloader.FileStatus, len(req.Files))
+	files := make(chan config.FileEntry)
+	for i := 0; i < min(fileStatusWorkers, len(req.Files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				status, err := h.downloader.CheckFileStatus(req.RootDir, f.Folder, f.FileName, f.Size, f.SHA256)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				statuses[f.ID] = status
+				mu.Unlock()
+			}
+		}()
+	}
 	for _, f := range req.Files {
-		statuses[f.ID] = h.downloader.CheckFileStatus(req.RootDir, f.Folder, f.FileName)
+		files <- f
+	}
+	close(files)
+	wg.Wait()
+
+	if firstErr != nil {
+		errorResponse(w, errorStatus(firstErr, http.StatusInternalServerError), firstErr.Error())
+		return
 	}
 	jsonResponse(w, FileStatusResponse{Statuses: statuses})
 }
 
 // DownloadRequest for downloading files
 type DownloadRequest struct {
-	RootDir string             `json:"rootDir"`
-	Token   string             `json:"token"`
-	Files   []config.FileEntry `json:"files"`
-	Force   bool               `json:"force"`
+	RootDir        string             `json:"rootDir"`
+	Token          string             `json:"token"`
+	HFToken        string             `json:"hfToken"` // HuggingFace token, sent as a bearer header
+	Files          []config.FileEntry `json:"files"`
+	Force          bool               `json:"force"`
+	MaxBytesPerSec int64              `json:"maxBytesPerSec"` // Aggregate bandwidth cap for the batch, 0 = unlimited
+	Connections    int                `json:"connections"`    // Parallel connections per file when the server supports ranges
+	ProxyURL       string             `json:"proxyUrl"`       // Explicit proxy, overrides the environment
+	StallTimeout   int                `json:"stallTimeout"`   // Seconds without progress before failing a download, 0 disables
+	WebhookURL     string             `json:"webhookUrl"`     // Notified when each download completes or fails
+	ConfigName     string             `json:"configName"`     // Reported in webhook payloads
+	Dedup          bool               `json:"dedup"`          // Link to identical files already under the root instead of keeping copies
+	AutoName       bool               `json:"autoName"`       // Name files without a file name after the server's suggestion
+	StartAt        time.Time          `json:"startAt"`        // Defer the batch until this time (RFC 3339), zero starts now
+	AllowHTML      bool               `json:"allowHTML"`      // Save HTML responses for model files instead of failing
+	DryRun         bool               `json:"dryRun"`         // Return what would be downloaded or skipped without downloading
+	VerifySize     bool               `json:"verifySize"`     // Only skip existing files whose size matches the server's
+	MinSize        int64              `json:"minSize"`        // Fail downloads smaller than this many bytes, empty ones always fail
+	Refresh        bool               `json:"refresh"`        // Re-download existing files only if the server's copy changed
+	WriteMeta      bool               `json:"writeMeta"`      // Write a .meta.json sidecar recording where each file came from
+	BatchID        string             `json:"batchId"`        // Client-chosen ID, a retry with the same one is refused while it runs
+
+	config.EntryDefaults // Of the config the files come from
 }
 
 // Download initiates downloads
@@ -174,25 +518,116 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
+	req.Files = req.ResolveAll(req.Files)
+
+	// Reject a bad root or entries that can't start before launching
+	// anything, the background job only reports per-file outcomes
+	if len(req.Files) == 0 {
+		errorResponse(w, http.StatusBadRequest, "no files to download")
+		return
+	}
+	if _, err := config.ResolveRoot(req.RootDir); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if issues := config.ValidateDownload(req.Files, req.AutoName); len(issues) > 0 {
+		problems := make([]string, len(issues))
+		for i, issue := range issues {
+			problems[i] = issue.String()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": strings.Join(problems, "; "), "issues": issues})
+		return
+	}
+	for _, f := range req.Files {
+		if _, err := config.EntryPath(req.RootDir, f.Folder, f.FileName); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Don't silently fall back to a direct connection on a bad proxy
+	if req.ProxyURL != "" {
+		if _, err := downloader.ParseProxyURL(req.ProxyURL); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	opts := downloader.DownloadOptions{
+		Token:       req.Token,
+		HFToken:     req.HFToken,
+		Force:       req.Force,
+		Connections: req.Connections,
+		ProxyURL:    req.ProxyURL,
+
+		StallTimeout: time.Duration(req.StallTimeout) * time.Second,
+		WebhookURL:   req.WebhookURL,
+		ConfigName:   req.ConfigName,
+		Dedup:        req.Dedup,
+		AutoName:     req.AutoName,
+		AllowHTML:    req.AllowHTML,
+		VerifySize:   req.VerifySize,
+		MinSize:      req.MinSize,
+		Refresh:      req.Refresh,
+		WriteMeta:    req.WriteMeta,
+	}
+	if req.MaxBytesPerSec > 0 {
+		// One limiter for the whole batch so the cap is global, not per file
+		opts.Limiter = downloader.NewRateLimiter(req.MaxBytesPerSec, downloader.BufferSize)
+	}
+
+	if req.DryRun {
+		plan, err := h.downloader.Plan(r.Context(), req.Files, req.RootDir, opts)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		jsonResponse(w, plan)
+		return
+	}
 
-	// Start downloads in background
-	go func() {
-		var wg sync.WaitGroup
-		for _, f := range req.Files {
-			wg.Add(1)
-			go func(entry config.FileEntry) {
-				defer wg.Done()
-				h.downloader.Download(context.Background(), entry, req.RootDir, req.Token, req.Force)
-			}(f)
+	// Start downloads in background; the stream gets a batch_complete
+	// event carrying batchId once all of them are done
+	batchID := req.BatchID
+	if batchID == "" {
+		batchID = config.NewID()
+	}
+	if req.StartAt.After(time.Now()) {
+		if err := h.downloader.Schedule(batchID, req.StartAt, req.Files, req.RootDir, opts); err != nil {
+			errorResponse(w, batchStatus(err), err.Error())
+			return
 		}
-		wg.Wait()
-	}()
+		jsonResponse(w, map[string]interface{}{"status": "scheduled", "batchId": batchID, "startAt": req.StartAt})
+		return
+	}
+	if err := h.downloader.StartBatch(batchID, req.Files, req.RootDir, opts); err != nil {
+		errorResponse(w, batchStatus(err), err.Error())
+		return
+	}
 
-	jsonResponse(w, map[string]string{"status": "started"})
+	jsonResponse(w, map[string]string{"status": "started", "batchId": batchID})
 }
 
-// CancelDownload cancels a download
+// batchStatus is the status code for an error starting a batch
+func batchStatus(err error) int {
+	if errors.Is(err, downloader.ErrBatchRunning) {
+		return http.StatusConflict
+	}
+	return http.StatusServiceUnavailable
+}
+
+// CancelDownload cancels a download, or with batchId a whole scheduled batch
 func (h *Handler) CancelDownload(w http.ResponseWriter, r *http.Request) {
+	if batchID := r.URL.Query().Get("batchId"); batchID != "" {
+		if err := h.downloader.CancelScheduled(batchID); err != nil {
+			errorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+		jsonResponse(w, map[string]string{"status": "cancelled"})
+		return
+	}
 	fileID := r.URL.Query().Get("id")
 	if fileID == "" {
 		errorResponse(w, http.StatusBadRequest, "file id required")
@@ -202,110 +637,591 @@ func (h *Handler) CancelDownload(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, map[string]string{"status": "cancelled"})
 }
 
+// PauseDownload stops a download, keeping its partial file for ResumeDownload
+func (h *Handler) PauseDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	fileID := r.URL.Query().Get("id")
+	if fileID == "" {
+		errorResponse(w, http.StatusBadRequest, "file id required")
+		return
+	}
+	if err := h.downloader.Pause(fileID); err != nil {
+		errorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	jsonResponse(w, map[string]string{"status": "paused"})
+}
+
+// ResumeDownload continues a paused download
+func (h *Handler) ResumeDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	fileID := r.URL.Query().Get("id")
+	if fileID == "" {
+		errorResponse(w, http.StatusBadRequest, "file id required")
+		return
+	}
+	if err := h.downloader.Resume(fileID); err != nil {
+		errorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	jsonResponse(w, map[string]string{"status": "resumed"})
+}
+
+// CancelAllDownloads cancels every active download
+func (h *Handler) CancelAllDownloads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	cancelled := h.downloader.CancelAll()
+	jsonResponse(w, map[string]interface{}{
+		"status":    "cancelled",
+		"cancelled": cancelled,
+	})
+}
+
 // GetProgress returns download progress
 func (h *Handler) GetProgress(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("aggregate") == "true" {
+		jsonResponse(w, h.downloader.GetAggregateProgress())
+		return
+	}
 	progress := h.downloader.GetAllProgress()
 	jsonResponse(w, progress)
 }
 
-// DeleteFileRequest for deleting a file
-type DeleteFileRequest struct {
-	RootDir  string `json:"rootDir"`
-	Folder   string `json:"folder"`
-	FileName string `json:"fileName"`
+// History page sizes
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
 }
 
-// DeleteFile deletes a file from disk
-func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
-	var req DeleteFileRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
-		return
+// SetBuildInfo sets what GetVersion reports. GoVersion is filled in from the
+// runtime when empty.
+func (h *Handler) SetBuildInfo(info BuildInfo) {
+	if info.GoVersion == "" {
+		info.GoVersion = runtime.Version()
 	}
+	h.buildInfo = info
+}
 
-	if err := h.downloader.DeleteFile(req.RootDir, req.Folder, req.FileName); err != nil {
-		errorResponse(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	jsonResponse(w, map[string]string{"status": "ok"})
+// GetVersion returns the version, commit and build date of the server
+func (h *Handler) GetVersion(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.buildInfo)
 }
 
-// ExtractRequest for extracting archive
-type ExtractRequest struct {
-	RootDir  string `json:"rootDir"`
-	Folder   string `json:"folder"`
-	FileName string `json:"fileName"`
+// GetStats returns combined and peak download speed and bytes received
+// since the server started
+func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.downloader.GetStats())
 }
 
-// ExtractArchive extracts an archive file
-func (h *Handler) ExtractArchive(w http.ResponseWriter, r *http.Request) {
-	var req ExtractRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+// GetHistory returns finished downloads from the history log, newest first.
+// Supports config and status filters and offset/limit paging.
+func (h *Handler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, err := queryInt(q.Get("offset"), 0)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid offset")
 		return
 	}
-
-	if !downloader.IsArchive(req.FileName) {
-		errorResponse(w, http.StatusBadRequest, "file is not a supported archive")
+	limit, err := queryInt(q.Get("limit"), defaultHistoryLimit)
+	if err != nil || limit == 0 {
+		errorResponse(w, http.StatusBadRequest, "invalid limit")
 		return
 	}
+	query := downloader.HistoryQuery{
+		Config: q.Get("config"),
+		Status: q.Get("status"),
+		Offset: offset,
+		Limit:  min(limit, maxHistoryLimit),
+	}
 
-	extracted, err := h.downloader.ExtractArchive(req.RootDir, req.Folder, req.FileName)
+	records, total, err := h.downloader.GetHistory(query)
 	if err != nil {
 		errorResponse(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	// Convert to config.ExtractedFile format
-	extractedFiles := make([]config.ExtractedFile, len(extracted))
-	for i, e := range extracted {
-		extractedFiles[i] = config.ExtractedFile{
-			Name: e.Name,
-			Size: e.Size,
-		}
-	}
-
 	jsonResponse(w, map[string]interface{}{
-		"status":    "ok",
-		"extracted": extractedFiles,
+		"total":   total,
+		"records": records,
 	})
 }
 
-// DeleteExtractedFileRequest for deleting an extracted file
-type DeleteExtractedFileRequest struct {
-	RootDir  string `json:"rootDir"`
-	Folder   string `json:"folder"`
-	FileName string `json:"fileName"` // Path to extracted file relative to folder
+// queryInt parses a non-negative integer query parameter, def if it's empty
+func queryInt(raw string, def int) (int, error) {
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number: %q", raw)
+	}
+	return n, nil
 }
 
-// DeleteExtractedFile deletes an extracted file from disk
-func (h *Handler) DeleteExtractedFile(w http.ResponseWriter, r *http.Request) {
-	var req DeleteExtractedFileRequest
+// DeleteFileRequest for deleting a file
+type DeleteFileRequest struct {
+	RootDir   string `json:"rootDir"`
+	Folder    string `json:"folder"`
+	FileName  string `json:"fileName"`
+	Permanent bool   `json:"permanent"` // Delete for good instead of moving to the trash
+}
+
+// DeleteFile moves a file to the root directory's trash, or deletes it from
+// disk when permanent is set
+func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	var req DeleteFileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
 
-	if err := h.downloader.DeleteExtractedFile(req.RootDir, req.Folder, req.FileName); err != nil {
-		errorResponse(w, http.StatusInternalServerError, err.Error())
+	remove := h.downloader.TrashFile
+	if req.Permanent {
+		remove = h.downloader.DeleteFile
+	}
+	if err := remove(req.RootDir, req.Folder, req.FileName); err != nil {
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
 		return
 	}
-
-	jsonResponse(w, map[string]string{"status": "ok"})
+	jsonResponse(w, map[string]interface{}{"status": "ok", "trashed": !req.Permanent})
 }
 
-// CheckArchive checks if file is an archive
-func (h *Handler) CheckArchive(w http.ResponseWriter, r *http.Request) {
-	fileName := r.URL.Query().Get("fileName")
-	isArchive := downloader.IsArchive(fileName)
-	jsonResponse(w, map[string]bool{"isArchive": isArchive})
+// DeleteFilesRequest for deleting several files at once
+type DeleteFilesRequest struct {
+	RootDir            string               `json:"rootDir"`
+	Files              []downloader.FileRef `json:"files"`
+	RemoveEmptyFolders bool                 `json:"removeEmptyFolders"` // Also remove folders the deletions leave empty
+	Permanent          bool                 `json:"permanent"`          // Delete for good instead of moving to the trash
 }
 
-// SSE endpoint for real-time progress updates
-func (h *Handler) ProgressStream(w http.ResponseWriter, r *http.Request) {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		errorResponse(w, http.StatusInternalServerError, "streaming not supported")
+// DeleteFiles moves a list of files to the trash, or deletes them when
+// permanent is set, reporting the outcome of each one. A file that can't be
+// deleted doesn't stop the others.
+func (h *Handler) DeleteFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req DeleteFilesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if _, err := config.ResolveRoot(req.RootDir); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := h.downloader.DeleteFiles(req.RootDir, req.Files, req.Permanent, req.RemoveEmptyFolders)
+	deleted := 0
+	for _, res := range results {
+		if res.Deleted {
+			deleted++
+		}
+	}
+	h.logger.Info("files deleted", "deleted", deleted, "failed", len(results)-deleted, "permanent", req.Permanent)
+	jsonResponse(w, map[string]interface{}{
+		"results": results,
+		"deleted": deleted,
+		"failed":  len(results) - deleted,
+		"trashed": !req.Permanent,
+	})
+}
+
+// trashStatus maps trash errors to HTTP status codes
+func trashStatus(err error) int {
+	switch {
+	case errors.Is(err, downloader.ErrNotInTrash):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrExist):
+		return http.StatusConflict
+	}
+	return errorStatus(err, http.StatusInternalServerError)
+}
+
+// ListTrash returns the deleted files waiting in a root directory's trash
+func (h *Handler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	items, err := h.downloader.ListTrash(r.URL.Query().Get("root"))
+	if err != nil {
+		errorResponse(w, trashStatus(err), err.Error())
+		return
+	}
+	var totalSize int64
+	for _, item := range items {
+		totalSize += item.Size
+	}
+	jsonResponse(w, map[string]interface{}{
+		"items":     items,
+		"totalSize": totalSize,
+	})
+}
+
+// RestoreTrashRequest for moving a trashed file back
+type RestoreTrashRequest struct {
+	RootDir string `json:"rootDir"`
+	ID      string `json:"id"`
+	Force   bool   `json:"force"` // Replace a file that now exists at the original location
+}
+
+// RestoreTrash moves a trashed file back to where it was deleted from
+func (h *Handler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req RestoreTrashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	item, err := h.downloader.RestoreTrash(req.RootDir, req.ID, req.Force)
+	if err != nil {
+		errorResponse(w, trashStatus(err), err.Error())
+		return
+	}
+	h.logger.Info("file restored from trash", "folder", item.Folder, "file", item.FileName)
+	jsonResponse(w, map[string]interface{}{"status": "ok", "item": item})
+}
+
+// PurgeTrashRequest for permanently deleting trashed files
+type PurgeTrashRequest struct {
+	RootDir string   `json:"rootDir"`
+	IDs     []string `json:"ids"` // Empty purges the whole trash
+}
+
+// PurgeTrash permanently deletes trashed files
+func (h *Handler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req PurgeTrashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	purged, err := h.downloader.PurgeTrash(req.RootDir, req.IDs)
+	if err != nil {
+		errorResponse(w, trashStatus(err), err.Error())
+		return
+	}
+	h.logger.Info("trash purged", "files", purged)
+	jsonResponse(w, map[string]interface{}{"status": "ok", "purged": purged})
+}
+
+// URLCheckRequest for probing remote files before downloading
+type URLCheckRequest struct {
+	Files    []config.FileEntry `json:"files"`
+	Token    string             `json:"token"`
+	HFToken  string             `json:"hfToken"`
+	ProxyURL string             `json:"proxyUrl"`
+
+	config.EntryDefaults // Of the config the files come from
+}
+
+// CheckURLs reports reachability and size of each file's URL
+func (h *Handler) CheckURLs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req URLCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	// Entries of the user's own configs may point at LAN hosts, so only
+	// the rate applies here
+	if !h.allowOutbound(w, r) {
+		return
+	}
+
+	results, err := h.downloader.CheckURLs(r.Context(), req.ResolveAll(req.Files), downloader.FileInfoOptions{
+		Token:    req.Token,
+		HFToken:  req.HFToken,
+		ProxyURL: req.ProxyURL,
+	})
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Total of the sizes we know, so the UI can show the batch size up front
+	var totalSize int64
+	for _, res := range results {
+		if res.Reachable && res.Size > 0 {
+			totalSize += res.Size
+		}
+	}
+	jsonResponse(w, map[string]interface{}{
+		"results":   results,
+		"totalSize": totalSize,
+	})
+}
+
+// MoveFileRequest for moving or renaming a downloaded file
+type MoveFileRequest struct {
+	RootDir   string `json:"rootDir"`
+	SrcFolder string `json:"srcFolder"`
+	SrcName   string `json:"srcName"`
+	DstFolder string `json:"dstFolder"`
+	DstName   string `json:"dstName"`
+	Force     bool   `json:"force"` // Overwrite an existing destination file
+}
+
+// MoveFile moves or renames a file within the root directory
+func (h *Handler) MoveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req MoveFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.SrcName == "" || req.DstName == "" {
+		errorResponse(w, http.StatusBadRequest, "source and destination file names required")
+		return
+	}
+
+	err := h.downloader.MoveFile(req.RootDir, req.SrcFolder, req.SrcName, req.DstFolder, req.DstName, req.Force)
+	if err != nil {
+		status := errorStatus(err, http.StatusInternalServerError)
+		if errors.Is(err, os.ErrExist) {
+			status = http.StatusConflict
+		}
+		errorResponse(w, status, err.Error())
+		return
+	}
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+// CleanupRequest for removing leftover temp files
+type CleanupRequest struct {
+	RootDir string `json:"rootDir"`
+}
+
+// CleanupTempFiles removes orphaned .tmp files under the root directory
+func (h *Handler) CleanupTempFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req CleanupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.RootDir == "" {
+		errorResponse(w, http.StatusBadRequest, "root directory required")
+		return
+	}
+
+	removed, err := h.downloader.CleanTempFiles(req.RootDir)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	jsonResponse(w, map[string]interface{}{
+		"status":  "ok",
+		"removed": removed,
+	})
+}
+
+// ExtractRequest for extracting archive
+type ExtractRequest struct {
+	RootDir     string `json:"rootDir"`
+	Folder      string `json:"folder"`
+	FileName    string `json:"fileName"`
+	DeleteAfter bool   `json:"deleteAfter"` // Move the archive to the trash once every entry extracted successfully
+	FileID      string `json:"fileId"`      // Config entry ID used to key progress events
+
+	// Entries limits extraction to these files, named as /api/archive/list
+	// reports them. Empty extracts the whole archive.
+	Entries []string `json:"entries,omitempty"`
+}
+
+// ExtractArchive extracts an archive file
+func (h *Handler) ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	var req ExtractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	if !downloader.IsArchive(req.FileName) {
+		errorResponse(w, http.StatusBadRequest, "file is not a supported archive")
+		return
+	}
+
+	extracted, err := h.downloader.ExtractArchive(req.RootDir, req.Folder, req.FileName, req.FileID, req.Entries)
+	if errors.Is(err, downloader.ErrUnsupportedArchive) || errors.Is(err, downloader.ErrArchiveEntryNotFound) {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	// Convert to config.ExtractedFile format
+	extractedFiles := make([]config.ExtractedFile, len(extracted))
+	for i, e := range extracted {
+		extractedFiles[i] = config.ExtractedFile{
+			Name: e.Name,
+			Size: e.Size,
+		}
+	}
+
+	// Extraction fully succeeded at this point, so the archive can go to the
+	// trash unless only some of its entries were wanted
+	archiveDeleted := false
+	response := map[string]interface{}{
+		"status":    "ok",
+		"extracted": extractedFiles,
+	}
+	if req.DeleteAfter && len(req.Entries) == 0 {
+		if err := h.downloader.TrashFile(req.RootDir, req.Folder, req.FileName); err != nil {
+			response["deleteError"] = err.Error()
+		} else {
+			archiveDeleted = true
+		}
+	}
+	response["archiveDeleted"] = archiveDeleted
+
+	jsonResponse(w, response)
+}
+
+// DeleteExtractedFileRequest for deleting an extracted file
+type DeleteExtractedFileRequest struct {
+	RootDir   string `json:"rootDir"`
+	Folder    string `json:"folder"`
+	FileName  string `json:"fileName"`  // Path to extracted file relative to folder
+	Permanent bool   `json:"permanent"` // Delete for good instead of moving to the trash
+}
+
+// DeleteExtractedFile moves an extracted file to the trash, or deletes it
+// from disk when permanent is set
+func (h *Handler) DeleteExtractedFile(w http.ResponseWriter, r *http.Request) {
+	var req DeleteExtractedFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	remove := h.downloader.TrashFile
+	if req.Permanent {
+		remove = h.downloader.DeleteExtractedFile
+	}
+	if err := remove(req.RootDir, req.Folder, req.FileName); err != nil {
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{"status": "ok", "trashed": !req.Permanent})
+}
+
+// CheckArchive checks if file is an archive
+func (h *Handler) CheckArchive(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("fileName")
+	isArchive := downloader.IsArchive(fileName)
+	jsonResponse(w, map[string]bool{"isArchive": isArchive})
+}
+
+// ListArchive returns the files inside an archive and their sizes without
+// extracting it
+func (h *Handler) ListArchive(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rootDir, fileName := q.Get("root"), q.Get("fileName")
+	if rootDir == "" || fileName == "" {
+		errorResponse(w, http.StatusBadRequest, "root directory and file name required")
+		return
+	}
+	if !downloader.IsArchive(fileName) {
+		errorResponse(w, http.StatusBadRequest, "file is not a supported archive")
+		return
+	}
+
+	files, err := h.downloader.ListArchive(rootDir, q.Get("folder"), fileName)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, downloader.ErrUnsupportedArchive) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		errorResponse(w, errorStatus(err, status), err.Error())
+		return
+	}
+
+	var totalSize int64
+	for _, f := range files {
+		totalSize += f.Size
+	}
+	jsonResponse(w, map[string]interface{}{"files": files, "totalSize": totalSize})
+}
+
+// GetFileMeta returns the metadata sidecar of a downloaded file: its source
+// URL, config, download time, size and checksum
+func (h *Handler) GetFileMeta(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rootDir, fileName := q.Get("root"), q.Get("fileName")
+	if rootDir == "" || fileName == "" {
+		errorResponse(w, http.StatusBadRequest, "root directory and file name required")
+		return
+	}
+
+	meta, err := h.downloader.ReadFileMeta(rootDir, q.Get("folder"), fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		errorResponse(w, http.StatusNotFound, "no metadata recorded for this file")
+		return
+	}
+	if err != nil {
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+	jsonResponse(w, meta)
+}
+
+// aggregateInterval is how often ProgressStream checks for batch progress changes
+const aggregateInterval = time.Second
+
+// aggregateEvent is a batch progress message on the progress stream
+type aggregateEvent struct {
+	Type string `json:"type"`
+	downloader.AggregateProgress
+}
+
+// SSE endpoint for real-time progress updates
+func (h *Handler) ProgressStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorResponse(w, http.StatusInternalServerError, "streaming not supported")
 		return
 	}
 
@@ -317,6 +1233,10 @@ func (h *Handler) ProgressStream(w http.ResponseWriter, r *http.Request) {
 
 	ch := h.downloader.Subscribe()
 	defer h.downloader.Unsubscribe(ch)
+	batches := h.downloader.SubscribeBatches()
+	defer h.downloader.UnsubscribeBatches(batches)
+	configs := h.configMgr.SubscribeChanges()
+	defer h.configMgr.UnsubscribeChanges(configs)
 
 	// Send initial connection message
 	fmt.Fprintf(w, "data: {\"type\":\"connected\"}\n\n")
@@ -326,6 +1246,11 @@ func (h *Handler) ProgressStream(w http.ResponseWriter, r *http.Request) {
 	heartbeat := time.NewTicker(15 * time.Second)
 	defer heartbeat.Stop()
 
+	// Batch totals are sent on their own, slower schedule and only when they change
+	aggregateTicker := time.NewTicker(aggregateInterval)
+	defer aggregateTicker.Stop()
+	var lastAggregate downloader.AggregateProgress
+
 	for {
 		select {
 		case <-r.Context().Done():
@@ -334,15 +1259,107 @@ func (h *Handler) ProgressStream(w http.ResponseWriter, r *http.Request) {
 			// Send heartbeat comment to keep connection alive
 			fmt.Fprintf(w, ": heartbeat\n\n")
 			flusher.Flush()
-		case progress := <-ch:
+		case progress, ok := <-ch:
+			if !ok {
+				return // Dropped for falling behind, the client will reconnect
+			}
 			data, _ := json.Marshal(progress)
 			fmt.Fprintf(w, "data: %s\n\n", data)
 			flusher.Flush()
+		case summary := <-batches:
+			data, _ := json.Marshal(summary)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case event := <-configs:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-aggregateTicker.C:
+			if data, changed := h.aggregateUpdate(&lastAggregate); changed {
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// aggregateUpdate returns the aggregate event if batch progress changed since last
+func (h *Handler) aggregateUpdate(last *downloader.AggregateProgress) ([]byte, bool) {
+	aggregate := h.downloader.GetAggregateProgress()
+	if aggregate == *last {
+		return nil, false
+	}
+	*last = aggregate
+	data, _ := json.Marshal(aggregateEvent{Type: "aggregate", AggregateProgress: aggregate})
+	return data, true
+}
+
+// ProgressWebSocket streams the same progress messages as ProgressStream over
+// a WebSocket, for clients behind proxies that buffer SSE
+func (h *Handler) ProgressWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return // Upgrade already responded
+	}
+	defer conn.Close()
+
+	ch := h.downloader.Subscribe()
+	defer h.downloader.Unsubscribe(ch)
+	batches := h.downloader.SubscribeBatches()
+	defer h.downloader.UnsubscribeBatches(batches)
+	configs := h.configMgr.SubscribeChanges()
+	defer h.configMgr.UnsubscribeChanges(configs)
+
+	if err := conn.WriteText([]byte(`{"type":"connected"}`)); err != nil {
+		return
+	}
+
+	// Pings keep the connection alive, like the SSE heartbeat
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+
+	aggregateTicker := time.NewTicker(aggregateInterval)
+	defer aggregateTicker.Stop()
+	var lastAggregate downloader.AggregateProgress
+
+	for {
+		var err error
+		select {
+		case <-conn.Done():
+			return
+		case <-ping.C:
+			err = conn.WritePing()
+		case progress, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(progress)
+			err = conn.WriteText(data)
+		case summary := <-batches:
+			data, _ := json.Marshal(summary)
+			err = conn.WriteText(data)
+		case event := <-configs:
+			data, _ := json.Marshal(event)
+			err = conn.WriteText(data)
+		case <-aggregateTicker.C:
+			if data, changed := h.aggregateUpdate(&lastAggregate); changed {
+				err = conn.WriteText(data)
+			}
+		}
+		if err != nil {
+			return
 		}
 	}
 }
 
-// ExportConfig exports a config as JSON for download
+// Metrics exposes download counters for Prometheus to scrape
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.downloader.WriteMetrics(w)
+}
+
+// ExportConfig exports a config as JSON for download. Tokens and other
+// credentials are omitted unless includeSecrets=true is passed.
 func (h *Handler) ExportConfig(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
@@ -356,6 +1373,11 @@ func (h *Handler) ExportConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Exports get shared, so secrets are left out unless asked for
+	if r.URL.Query().Get("includeSecrets") != "true" {
+		cfg.StripSecrets()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.json\"", name))
 	json.NewEncoder(w).Encode(cfg)
@@ -368,17 +1390,273 @@ func (h *Handler) ImportConfig(w http.ResponseWriter, r *http.Request) {
 		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
+	h.importConfig(w, r, &cfg, true, nil)
+}
+
+// ImportURLRequest for importing a config shared as a raw JSON link
+type ImportURLRequest struct {
+	URL string `json:"url"`
+}
 
+// ImportConfigFromURL fetches a config from a URL and imports it. Secrets
+// in a shared config belong to someone else, so they are dropped and the
+// response reports secretsStripped. Nor does it take on the secrets of a
+// local config it replaces: those would be sent to the hosts it lists.
+func (h *Handler) ImportConfigFromURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req ImportURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.URL == "" {
+		errorResponse(w, http.StatusBadRequest, "url required")
+		return
+	}
+	if !h.allowOutbound(w, r, req.URL) {
+		return
+	}
+
+	cfg, err := config.FetchConfig(r.Context(), h.downloader.HTTPClient(), req.URL)
+	if err != nil {
+		h.logger.Warn("config import from URL failed", "error", err)
+		errorResponse(w, errorStatus(err, http.StatusBadGateway), err.Error())
+		return
+	}
+	stripped := cfg.StripSecrets()
+	h.importConfig(w, r, cfg, false, map[string]interface{}{"secretsStripped": stripped})
+}
+
+// importConfig validates and saves an imported config, adding extra to the
+// response. With keepSecrets, a config replacing one of the same name keeps
+// the secrets an export left out.
+func (h *Handler) importConfig(w http.ResponseWriter, r *http.Request, cfg *config.Config, keepSecrets bool, extra map[string]interface{}) {
 	if cfg.Name == "" {
 		errorResponse(w, http.StatusBadRequest, "config name required")
 		return
 	}
 
-	if err := h.configMgr.SaveConfig(&cfg); err != nil {
+	remapped, err := assignConfigIDs(r, cfg)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Exports omit tokens by default; re-importing one shouldn't drop ours
+	if keepSecrets {
+		if existing, err := h.configMgr.LoadConfig(cfg.Name); err == nil {
+			cfg.KeepSecrets(existing)
+		}
+	}
+
+	if err := h.configMgr.SaveConfig(cfg); err != nil {
+		h.logger.Warn("config import failed", "name", cfg.Name, "error", err)
+		errorResponse(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+	h.logger.Info("config imported", "name", cfg.Name, "files", len(cfg.Files), "remappedIds", len(remapped))
+
+	resp := map[string]interface{}{"status": "ok", "name": cfg.Name}
+	if len(remapped) > 0 {
+		resp["remappedIds"] = remapped
+	}
+	if warnings := cfg.Warnings(); len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	jsonResponse(w, resp)
+}
+
+// ListBackups returns the saved previous versions of a config
+func (h *Handler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		errorResponse(w, http.StatusBadRequest, "config name required")
+		return
+	}
+
+	backups, err := h.configMgr.ListBackups(name)
+	if err != nil {
 		errorResponse(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	jsonResponse(w, map[string]string{"status": "ok", "name": cfg.Name})
+	jsonResponse(w, backups)
+}
+
+// RestoreRequest for rolling a config back to a backup
+type RestoreRequest struct {
+	Name     string `json:"name"`
+	BackupID string `json:"backupId"`
+}
+
+// RestoreBackup replaces a config with one of its backups
+func (h *Handler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Name == "" || req.BackupID == "" {
+		errorResponse(w, http.StatusBadRequest, "config name and backup id required")
+		return
+	}
+
+	if err := h.configMgr.RestoreBackup(req.Name, req.BackupID); err != nil {
+		errorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	h.logger.Info("config restored from backup", "name", req.Name, "backupId", req.BackupID)
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+// ValidateConfig checks a config the way saving it would, returning every
+// error and warning with the index of the entry it concerns. Nothing is saved.
+func (h *Handler) ValidateConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var cfg config.Config
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	issues := config.Validate(&cfg)
+	valid := true
+	for _, issue := range issues {
+		if issue.Severity == config.SeverityError {
+			valid = false
+		}
+	}
+	jsonResponse(w, map[string]interface{}{"valid": valid, "issues": issues})
+}
+
+// MergeRequest for combining several configs into a new one
+type MergeRequest struct {
+	Names   []string `json:"names"`
+	NewName string   `json:"newName"`
+}
+
+// MergeConfigs saves the files of several configs as a new config,
+// reporting duplicates skipped and sources with a different root directory
+func (h *Handler) MergeConfigs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if len(req.Names) < 2 || req.NewName == "" {
+		errorResponse(w, http.StatusBadRequest, "at least two config names and a new name required")
+		return
+	}
+
+	cfg, report, err := h.configMgr.MergeConfigs(req.Names, req.NewName)
+	if err != nil {
+		h.logger.Warn("config merge failed", "names", req.Names, "error", err)
+		errorResponse(w, errorStatus(err, http.StatusNotFound), err.Error())
+		return
+	}
+	h.logger.Info("configs merged", "names", req.Names, "name", cfg.Name, "merged", report.Merged, "skipped", report.Skipped, "rootConflicts", len(report.RootConflicts))
+
+	jsonResponse(w, map[string]interface{}{
+		"status":        "ok",
+		"name":          cfg.Name,
+		"merged":        report.Merged,
+		"skipped":       report.Skipped,
+		"rootDirectory": report.RootDirectory,
+		"rootConflicts": report.RootConflicts,
+	})
+}
+
+// CopyConfigRequest for duplicating a config
+type CopyConfigRequest struct {
+	Name    string `json:"name"`    // Config to copy
+	NewName string `json:"newName"` // Name of the copy
+	Force   bool   `json:"force"`   // Replace an existing config called NewName
+}
+
+// CopyConfig saves a duplicate of a config under a new name
+func (h *Handler) CopyConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req CopyConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Name == "" || req.NewName == "" {
+		errorResponse(w, http.StatusBadRequest, "config name and new name required")
+		return
+	}
+
+	name, err := h.configMgr.CopyConfig(req.Name, req.NewName, req.Force)
+	if err != nil {
+		status := errorStatus(err, http.StatusNotFound)
+		if errors.Is(err, config.ErrConfigExists) {
+			status = http.StatusConflict
+		}
+		errorResponse(w, status, err.Error())
+		return
+	}
+	h.logger.Info("config copied", "from", req.Name, "name", name)
+	jsonResponse(w, map[string]string{"status": "ok", "name": name})
+}
+
+// RenameConfigRequest for renaming a config
+type RenameConfigRequest struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+}
+
+// RenameConfig renames a config and its file, returning the new name
+func (h *Handler) RenameConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req RenameConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Name == "" || req.NewName == "" {
+		errorResponse(w, http.StatusBadRequest, "config name and new name required")
+		return
+	}
+
+	name, err := h.configMgr.RenameConfig(req.Name, req.NewName)
+	if err != nil {
+		status := errorStatus(err, http.StatusNotFound)
+		if errors.Is(err, config.ErrConfigExists) {
+			status = http.StatusConflict
+		}
+		errorResponse(w, status, err.Error())
+		return
+	}
+	h.logger.Info("config renamed", "from", req.Name, "name", name)
+	jsonResponse(w, map[string]string{"status": "ok", "name": name})
 }
 
 // ConfigHandler routes /api/config based on method
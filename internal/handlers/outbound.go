@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"multy-loader/internal/downloader"
+)
+
+// outboundIdleTTL is how long a client's bucket is kept after its last request
+const outboundIdleTTL = 10 * time.Minute
+
+// OutboundLimits guards the endpoints that make requests to a URL the client
+// chooses (file info, URL checks, config import from URL)
+type OutboundLimits struct {
+	PerMinute float64 // Requests per minute per client IP, 0 disables the limit
+	Burst     int     // Requests a client may make at once before being limited
+
+	// AllowedHosts, when set, are the only hosts (and their subdomains)
+	// these endpoints reach. Listing an internal host here allows it.
+	AllowedHosts []string
+
+	AllowInternal bool // Don't reject localhost and private IP literals up front
+}
+
+// outboundGuard holds a token bucket per client IP
+type outboundGuard struct {
+	limits OutboundLimits
+
+	mu      sync.Mutex
+	clients map[string]*outboundClient
+	pruned  time.Time
+}
+
+type outboundClient struct {
+	limiter *downloader.RateLimiter
+	seen    time.Time
+}
+
+// SetOutboundLimits rate limits and restricts the hosts of endpoints that
+// fetch client-supplied URLs
+func (h *Handler) SetOutboundLimits(limits OutboundLimits) {
+	h.outbound = &outboundGuard{limits: limits, clients: make(map[string]*outboundClient)}
+}
+
+// allowOutbound applies the outbound limits to a request about to fetch
+// urls. It answers 429 or 403 itself and returns false when the request
+// must stop.
+func (h *Handler) allowOutbound(w http.ResponseWriter, r *http.Request, urls ...string) bool {
+	g := h.outbound
+	if g == nil {
+		return true
+	}
+	for _, raw := range urls {
+		if err := g.checkURL(raw); err != "" {
+			errorResponse(w, http.StatusForbidden, err)
+			return false
+		}
+	}
+	if !g.allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		errorResponse(w, http.StatusTooManyRequests, "too many requests, try again later")
+		return false
+	}
+	return true
+}
+
+// checkURL returns why rawURL may not be fetched, or "" if it may. Invalid
+// URLs are left for the endpoint to report.
+func (g *outboundGuard) checkURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "only http and https URLs can be fetched"
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(g.limits.AllowedHosts) > 0 {
+		for _, allowed := range g.limits.AllowedHosts {
+			allowed = strings.ToLower(allowed)
+			if host == allowed || strings.HasSuffix(host, "."+allowed) {
+				return ""
+			}
+		}
+		return "host " + host + " is not in the allowed hosts"
+	}
+	if !g.limits.AllowInternal && downloader.IsInternalHost(host) {
+		return "host " + host + " is an internal address"
+	}
+	return ""
+}
+
+// allow takes a token from the client's bucket
+func (g *outboundGuard) allow(client string) bool {
+	if g.limits.PerMinute <= 0 {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if now.Sub(g.pruned) > outboundIdleTTL {
+		for ip, c := range g.clients {
+			if now.Sub(c.seen) > outboundIdleTTL {
+				delete(g.clients, ip)
+			}
+		}
+		g.pruned = now
+	}
+
+	c, ok := g.clients[client]
+	if !ok {
+		c = &outboundClient{limiter: downloader.NewRequestLimiter(g.limits.PerMinute, g.limits.Burst)}
+		g.clients[client] = c
+	}
+	c.seen = now
+	return c.limiter.Allow()
+}
+
+// clientIP returns the IP of the connection a request came in on. Forwarded
+// headers are ignored since anyone can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"multy-loader/internal/config"
 	"multy-loader/internal/downloader"
@@ -17,11 +27,32 @@ import (
 //go:embed web/templates/*
 var webFS embed.FS
 
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+const (
+	// shutdownGracePeriod is how long running downloads may keep going after Ctrl-C
+	shutdownGracePeriod = 30 * time.Second
+
+	// shutdownTimeout bounds each remaining shutdown step
+	shutdownTimeout = 10 * time.Second
+
+	// configWatchInterval is how often the configs directory is checked for changes
+	configWatchInterval = time.Second
+)
+
 func main() {
+	logger := newLogger(os.Getenv("LOG_LEVEL"))
+	slog.SetDefault(logger)
+
 	// Get executable directory for configs
 	execPath, err := os.Executable()
 	if err != nil {
-		log.Fatal("Failed to get executable path:", err)
+		fatal("failed to get executable path", err)
 	}
 	execDir := filepath.Dir(execPath)
 	configsDir := filepath.Join(execDir, "configs")
@@ -35,14 +66,83 @@ func main() {
 	// Initialize config manager
 	cfgMgr, err := config.NewManager(configsDir)
 	if err != nil {
-		log.Fatal("Failed to initialize config manager:", err)
+		fatal("failed to initialize config manager", err)
+	}
+
+	// Outbound requests can't reach internal addresses unless allowed
+	allowPrivate := false
+	if raw := os.Getenv("ALLOW_PRIVATE"); raw != "" {
+		if allowPrivate, err = strconv.ParseBool(raw); err != nil {
+			fatal("invalid ALLOW_PRIVATE", fmt.Errorf("%q is not a boolean", raw))
+		}
 	}
+	downloader.AllowInternalAddresses(allowPrivate)
 
-	// Initialize downloader
-	dl := downloader.NewDownloader()
+	// Limit parallel downloads, the rest wait in a queue
+	var maxDownloads int
+	if raw := os.Getenv("MAX_DOWNLOADS"); raw != "" {
+		if maxDownloads, err = strconv.Atoi(raw); err != nil || maxDownloads < 0 {
+			fatal("invalid MAX_DOWNLOADS", fmt.Errorf("%q is not a non-negative number", raw))
+		}
+	}
+
+	// Cap connections to one host, zero is unlimited
+	var maxConnsPerHost int
+	if raw := os.Getenv("MAX_CONNS_PER_HOST"); raw != "" {
+		if maxConnsPerHost, err = strconv.Atoi(raw); err != nil || maxConnsPerHost < 0 {
+			fatal("invalid MAX_CONNS_PER_HOST", fmt.Errorf("%q is not a non-negative number", raw))
+		}
+	}
+
+	// Identify as this build unless told to pretend otherwise
+	userAgent := os.Getenv("USER_AGENT")
+	if userAgent == "" {
+		userAgent = "multy-loader/" + version
+	}
+
+	// Trust a private CA for internal artifact servers. Skipping verification
+	// altogether has to be asked for explicitly.
+	var rootCAs *x509.CertPool
+	if path := os.Getenv("CA_BUNDLE"); path != "" {
+		if rootCAs, err = downloader.LoadCABundle(path); err != nil {
+			fatal("invalid CA_BUNDLE", err)
+		}
+	}
+	insecureTLS := false
+	if raw := os.Getenv("INSECURE_TLS"); raw != "" {
+		if insecureTLS, err = strconv.ParseBool(raw); err != nil {
+			fatal("invalid INSECURE_TLS", fmt.Errorf("%q is not a boolean", raw))
+		}
+	}
+	if insecureTLS {
+		logger.Warn("TLS certificate verification is disabled for downloads")
+	}
+
+	// Initialize downloader, keeping its progress next to the configs
+	dl := downloader.NewDownloader(downloader.DownloaderOptions{
+		StatePath:  filepath.Join(configsDir, ".state", "progress.json"),
+		Logger:     logger,
+		WebhookURL: os.Getenv("WEBHOOK_URL"),
+		HashIndex:  filepath.Join(configsDir, ".state", "hashes.json"),
+		HistoryLog: filepath.Join(configsDir, "history.jsonl"),
+
+		MaxConcurrent:   maxDownloads,
+		MaxConnsPerHost: maxConnsPerHost,
+		UserAgent:       userAgent,
+
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: insecureTLS,
+	})
+
+	// Remove temp files left behind by downloads that never finished
+	go cleanTempFiles(cfgMgr, dl)
 
 	// Initialize handlers
-	h := handlers.NewHandler(cfgMgr, dl)
+	h := handlers.NewHandler(cfgMgr, dl, logger)
+	h.SetBuildInfo(buildInfo())
+	limits := outboundLimits()
+	limits.AllowInternal = allowPrivate
+	h.SetOutboundLimits(limits)
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -52,23 +152,47 @@ func main() {
 	mux.HandleFunc("/api/config", h.ConfigHandler)
 	mux.HandleFunc("/api/config/export", h.ExportConfig)
 	mux.HandleFunc("/api/config/import", h.ImportConfig)
-	mux.HandleFunc("/api/folders", h.GetFolders)
+	mux.HandleFunc("/api/config/import-url", h.ImportConfigFromURL)
+	mux.HandleFunc("/api/config/backups", h.ListBackups)
+	mux.HandleFunc("/api/config/merge", h.MergeConfigs)
+	mux.HandleFunc("/api/config/copy", h.CopyConfig)
+	mux.HandleFunc("/api/config/rename", h.RenameConfig)
+	mux.HandleFunc("/api/config/validate", h.ValidateConfig)
+	mux.HandleFunc("/api/config/restore", h.RestoreBackup)
+	mux.HandleFunc("/api/folders", h.FoldersHandler)
 	mux.HandleFunc("/api/files/status", h.CheckFileStatus)
+	mux.HandleFunc("/api/files/delete", h.DeleteFiles)
+	mux.HandleFunc("/api/trash", h.ListTrash)
+	mux.HandleFunc("/api/trash/restore", h.RestoreTrash)
+	mux.HandleFunc("/api/trash/purge", h.PurgeTrash)
 	mux.HandleFunc("/api/check-civitai", h.CheckCivitaiURL)
 	mux.HandleFunc("/api/file-info", h.GetFileInfo)
+	mux.HandleFunc("/api/urls/check", h.CheckURLs)
 	mux.HandleFunc("/api/download/cancel", h.CancelDownload)
+	mux.HandleFunc("/api/download/cancel-all", h.CancelAllDownloads)
+	mux.HandleFunc("/api/download/pause", h.PauseDownload)
+	mux.HandleFunc("/api/download/resume", h.ResumeDownload)
 	mux.HandleFunc("/api/download", h.Download)
 	mux.HandleFunc("/api/progress", h.GetProgress)
+	mux.HandleFunc("/api/history", h.GetHistory)
+	mux.HandleFunc("/api/stats", h.GetStats)
 	mux.HandleFunc("/api/progress/stream", h.ProgressStream)
+	mux.HandleFunc("/api/progress/ws", h.ProgressWebSocket)
 	mux.HandleFunc("/api/file", h.FileHandler)
+	mux.HandleFunc("/api/file/move", h.MoveFile)
+	mux.HandleFunc("/api/file/meta", h.GetFileMeta)
 	mux.HandleFunc("/api/extract", h.ExtractArchive)
 	mux.HandleFunc("/api/extract/delete", h.DeleteExtractedFile)
+	mux.HandleFunc("/api/archive/list", h.ListArchive)
 	mux.HandleFunc("/api/is-archive", h.CheckArchive)
+	mux.HandleFunc("/api/cleanup", h.CleanupTempFiles)
+	mux.HandleFunc("/api/version", h.GetVersion)
+	mux.HandleFunc("/metrics", h.Metrics)
 
 	// Serve embedded static files
 	templatesFS, err := fs.Sub(webFS, "web/templates")
 	if err != nil {
-		log.Fatal("Failed to get templates FS:", err)
+		fatal("failed to get templates FS", err)
 	}
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -85,17 +209,172 @@ func main() {
 		w.Write(data)
 	})
 
-	// Start server
+	// Start server, only reachable from this machine unless HOST says otherwise
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "9894"
 	}
+	host := os.Getenv("HOST")
+	if host == "" {
+		host = os.Getenv("BIND_ADDR")
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
 
-	addr := fmt.Sprintf(":%s", port)
-	fmt.Printf("🚀 Multy Loader starting on http://localhost%s\n", addr)
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fatal("failed to listen", err)
+	}
+	fmt.Printf("🚀 Multy Loader %s listening on http://%s\n", version, listener.Addr())
 	fmt.Printf("📁 Configs directory: %s\n", configsDir)
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatal("Server failed:", err)
+	// Require API_TOKEN on API calls when it is set
+	var handler http.Handler = mux
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		handler = handlers.RequireToken(token, mux)
+		fmt.Println("🔒 API token required")
+	}
+
+	// Cancelled on shutdown so long-lived progress streams return
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+
+	// Tell open pages about configs changed by other tabs or edited on disk
+	go cfgMgr.Watch(baseCtx, configWatchInterval)
+
+	server := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("server failed", err)
+	case <-stop:
+	}
+
+	shutdown(server, cancelBase, dl, stop)
+}
+
+// buildInfo returns the version set at build time. Binaries built without
+// -ldflags fall back to the commit go build records.
+func buildInfo() handlers.BuildInfo {
+	info := handlers.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = bi.GoVersion
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// outboundLimits reads the limits for endpoints that fetch client-supplied
+// URLs: OUTBOUND_RATE requests per minute per client (0 disables),
+// OUTBOUND_BURST and a comma-separated OUTBOUND_HOSTS allow-list
+func outboundLimits() handlers.OutboundLimits {
+	limits := handlers.OutboundLimits{PerMinute: 60, Burst: 10}
+	if raw := os.Getenv("OUTBOUND_RATE"); raw != "" {
+		rate, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rate < 0 {
+			fatal("invalid OUTBOUND_RATE", fmt.Errorf("%q is not a non-negative number", raw))
+		}
+		limits.PerMinute = rate
+	}
+	if raw := os.Getenv("OUTBOUND_BURST"); raw != "" {
+		burst, err := strconv.Atoi(raw)
+		if err != nil || burst < 1 {
+			fatal("invalid OUTBOUND_BURST", fmt.Errorf("%q is not a positive number", raw))
+		}
+		limits.Burst = burst
+	}
+	for _, host := range strings.Split(os.Getenv("OUTBOUND_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			limits.AllowedHosts = append(limits.AllowedHosts, host)
+		}
+	}
+	return limits
+}
+
+// newLogger creates a text logger for the LOG_LEVEL value (debug, info, warn or error)
+func newLogger(level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		lvl = slog.LevelInfo
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: lvl}))
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// shutdown stops the HTTP server, lets running downloads finish within the
+// grace period and cancels whatever is left. A second signal skips the wait.
+func shutdown(server *http.Server, cancelStreams context.CancelFunc, dl *downloader.Downloader, stop <-chan os.Signal) {
+	fmt.Println("🛑 Shutting down, press Ctrl+C again to stop downloads immediately")
+
+	cancelStreams()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("server shutdown", "error", err)
+	}
+	cancel()
+
+	graceCtx, cancelGrace := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	go func() {
+		select {
+		case <-stop:
+			cancelGrace()
+		case <-graceCtx.Done():
+		}
+	}()
+	if err := dl.Wait(graceCtx); err != nil {
+		fmt.Println("⏹  Cancelling unfinished downloads")
+	}
+	cancelGrace()
+
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := dl.Shutdown(ctx); err != nil {
+		slog.Warn("downloads did not stop in time", "error", err)
+	}
+}
+
+// cleanTempFiles removes orphaned .tmp files from the root directory of every config
+func cleanTempFiles(cfgMgr *config.Manager, dl *downloader.Downloader) {
+	names, err := cfgMgr.ListConfigs()
+	if err != nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		cfg, err := cfgMgr.LoadConfig(name)
+		if err != nil || cfg.RootDirectory == "" {
+			continue
+		}
+		root, err := config.ResolveRoot(cfg.RootDirectory)
+		if err != nil || seen[root] {
+			continue
+		}
+		seen[root] = true
+
+		removed, err := dl.CleanTempFiles(root)
+		if err == nil && len(removed) > 0 {
+			fmt.Printf("🧹 Removed %d temp files from %s\n", len(removed), root)
+		}
 	}
 }